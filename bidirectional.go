@@ -0,0 +1,87 @@
+package pie
+
+import (
+	"io"
+	"net/rpc"
+)
+
+// StartBidirectional starts a plugin application that both provides an API to
+// the host and consumes an API from it, such as the host's logging or storage,
+// over the same connection, which is multiplexed using a Session.  It returns
+// a Plugin for calling the plugin's API, and a Server for the host to register
+// its own API with and then serve, usually in a goroutine.  Calls the plugin
+// makes before the Server is served wait until it is.  Closing either the
+// Plugin or the Server shuts down the plugin application.
+//
+// The plugin application must call NewBidirectional.
+func StartBidirectional(output io.Writer, path string, args ...string) (*Plugin, Server, error) {
+	return StartBidirectionalWith(output, path, args)
+}
+
+// StartBidirectionalWith is like StartBidirectional, but configures the plugin
+// application with the given options.  WithCodec sets the codec of the
+// Plugin's RPC client; the Server's codec is chosen by calling Serve or
+// ServeCodec.
+func StartBidirectionalWith(output io.Writer, path string, args []string, opts ...Option) (*Plugin, Server, error) {
+	cfg := newConfig(opts)
+	cfg.provider = true
+	pipe, err := launch(makeCommand(output, path, args), cfg)
+	if err != nil {
+		return nil, Server{}, err
+	}
+	sess := newSession(pipe, true)
+	p := newPlugin(cfg.client(sess.rpcConn(hostCalls)), pipe)
+	p.session = sess
+	s := newServer(sess.rpcConn(pluginCalls))
+	s.session = sess
+	return p, s, nil
+}
+
+// NewBidirectional returns a Server for this plugin application to register
+// and serve its API with, like NewProvider, and an rpc.Client that calls the
+// API of the host, like NewConsumer, sharing the connection to a host that
+// called StartBidirectional.  The client uses gob encoding.  If the host
+// started this application using WithHandshake, NewBidirectional answers the
+// handshake before returning, so the services registered with the Server
+// aren't listed in the plugin's PluginInfo.
+func NewBidirectional() (Server, *rpc.Client) {
+	s, rwc := newBidirectional("gob")
+	return s, rpc.NewClient(rwc)
+}
+
+// NewBidirectionalCodec is like NewBidirectional, but the client uses the
+// ClientCodec returned by f.  The handshake is answered as with
+// NewConsumerCodec.
+func NewBidirectionalCodec(f func(io.ReadWriteCloser) rpc.ClientCodec) (Server, *rpc.Client) {
+	s, rwc := newBidirectional("")
+	return s, rpc.NewClientWithCodec(f(rwc))
+}
+
+// newBidirectional answers the host's handshake, if any, as the named codec,
+// and multiplexes the connection to the host.  It returns the plugin's Server and
+// the connection for its client.
+func newBidirectional(codec string) (Server, io.ReadWriteCloser) {
+	rwc := stdio()
+	logHandshake(answerHandshake(rwc, codec, nil))
+	sess := newSession(rwc, false)
+	s := newServer(sess.rpcConn(hostCalls))
+	s.state.signals = true
+	s.session = sess
+	return s, sess.rpcConn(pluginCalls)
+}
+
+// Session returns the Session that the Plugin's connection is multiplexed
+// over, which can be used to open other streams to the plugin application.
+// It is nil unless the plugin was started by StartBidirectional, or using
+// WithStreams.
+func (p *Plugin) Session() *Session {
+	return p.session
+}
+
+// Session returns the Session that the Server's connection is multiplexed
+// over, which can be used to open other streams to the other side.  It is nil
+// unless the Server was returned by StartBidirectional or NewBidirectional, or
+// by NewProvider in a plugin application started using WithStreams.
+func (s Server) Session() *Session {
+	return s.session
+}
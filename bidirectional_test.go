@@ -0,0 +1,116 @@
+package pie
+
+import (
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"strings"
+	"testing"
+)
+
+// relay is an API served by bidirectional plugins that calls back into the
+// host.
+type relay struct {
+	host *rpc.Client
+}
+
+// SayHi asks the host's api.SayHi to greet name.
+func (r relay) SayHi(name string, response *string) error {
+	return r.host.Call("api.SayHi", name, response)
+}
+
+// serveBidirectional serves the test APIs, and relay, as a bidirectional
+// plugin, and echoes back whatever is sent on the streams the host opens.
+func serveBidirectional() {
+	s, host := NewBidirectional()
+	s.RegisterName("helper", helper{})
+	s.RegisterName("relay", relay{host})
+	go func() {
+		for {
+			st, err := s.Session().Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(st, st)
+				st.Close()
+			}()
+		}
+	}()
+	s.Serve()
+}
+
+func TestBidirectional(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	p, s, err := StartBidirectional(os.Stderr, os.Args[0], helperArgs("bidirectional")...)
+	if err != nil {
+		t.Fatalf("Unexpected error from StartBidirectional: %#v", err)
+	}
+	s.RegisterName("api", api{})
+	serveConsumer(t, s)
+
+	var reply string
+	if err := p.Call("relay.SayHi", "host", &reply); err != nil {
+		t.Fatalf("Unexpected error from call that calls back into the host: %#v", err)
+	}
+	if reply != "Hi host" {
+		t.Errorf("Expected reply %q from host, got %q", "Hi host", reply)
+	}
+	// A large argument is sent in several frames.
+	big := strings.Repeat("x", 3*maxFrame)
+	if err := p.Call("relay.SayHi", big, &reply); err != nil || reply != "Hi "+big {
+		t.Errorf("Expected large reply from host, got %d bytes, %#v", len(reply), err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+}
+
+func TestBidirectionalStreams(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	p, s, err := StartBidirectional(os.Stderr, os.Args[0], helperArgs("bidirectional")...)
+	if err != nil {
+		t.Fatalf("Unexpected error from StartBidirectional: %#v", err)
+	}
+	defer p.Close()
+	serveConsumer(t, s)
+	if p.Session() == nil || p.Session() != s.Session() {
+		t.Fatal("Expected Plugin and Server to share a Session")
+	}
+
+	st, err := p.Session().Open()
+	if err != nil {
+		t.Fatalf("Unexpected error from Open: %#v", err)
+	}
+	data := strings.Repeat("stream data ", 100000)
+	go func() {
+		io.WriteString(st, data)
+		st.Close()
+	}()
+	b, err := ioutil.ReadAll(st)
+	if err != nil || string(b) != data {
+		t.Fatalf("Expected %d bytes echoed by the plugin, got %d, %#v", len(data), len(b), err)
+	}
+	// RPC still works alongside the stream.
+	if err := p.Call("helper.PID", 0, new(int)); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+}
+
+func TestBidirectionalHandshake(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	p, s, err := StartBidirectionalWith(os.Stderr, os.Args[0], helperArgs("bidirectional"),
+		WithHandshake(Handshake{}))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartBidirectionalWith: %#v", err)
+	}
+	defer p.Close()
+	serveConsumer(t, s)
+	if codec := p.Info().Codec; codec != "gob" {
+		t.Errorf("Expected plugin to answer handshake with codec %q, got %q", "gob", codec)
+	}
+	if err := p.Call("helper.PID", 0, new(int)); err != nil {
+		t.Fatalf("Unexpected error from Call after handshake: %#v", err)
+	}
+}
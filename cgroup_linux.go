@@ -0,0 +1,118 @@
+package pie
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroupPeriod is the period, in microseconds, used for cpu.max.
+const cgroupPeriod = 100000
+
+// cgroupSeq numbers the cgroups created by this process.
+var cgroupSeq uint64
+
+// cgroupDir is a cgroup created for a plugin application.
+type cgroupDir struct {
+	path string
+	// dir is the open cgroup directory, whose file descriptor tells the
+	// process to start in the cgroup.
+	dir *os.File
+}
+
+// newCgroup creates a cgroup for the plugin according to cg, and makes c start
+// the plugin in it.
+func newCgroup(c execCmd, cg Cgroup) (*cgroupDir, error) {
+	name := fmt.Sprintf("pie-%d-%d", os.Getpid(), atomic.AddUint64(&cgroupSeq, 1))
+	d := &cgroupDir{path: filepath.Join(cg.Parent, name)}
+	if err := os.Mkdir(d.path, 0755); err != nil {
+		return nil, fmt.Errorf("can't create cgroup: %w", err)
+	}
+	err := d.limit(cg)
+	if err == nil {
+		d.dir, err = os.Open(d.path)
+	}
+	if err != nil {
+		os.Remove(d.path)
+		return nil, err
+	}
+	attr := ownSysProcAttr(c)
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(d.dir.Fd())
+	return d, nil
+}
+
+// limit writes the cgroup's limits.
+func (d *cgroupDir) limit(cg Cgroup) error {
+	if cg.MemoryMax > 0 {
+		if err := d.write("memory.max", strconv.FormatUint(cg.MemoryMax, 10)); err != nil {
+			return err
+		}
+	}
+	if cg.CPUMax > 0 {
+		quota := int64(cg.CPUMax * cgroupPeriod)
+		if quota < 1000 {
+			// The kernel doesn't allow less than a millisecond per period.
+			quota = 1000
+		}
+		if err := d.write("cpu.max", fmt.Sprintf("%d %d", quota, cgroupPeriod)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes value to the cgroup's file with the given name.
+func (d *cgroupDir) write(name, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(d.path, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("can't set cgroup %s, is the controller enabled in the parent's cgroup.subtree_control? %w", name, err)
+	}
+	return nil
+}
+
+// started closes the host's copy of the cgroup directory, once the plugin has
+// been started or has failed to start.
+func (d *cgroupDir) started() {
+	if d != nil {
+		d.dir.Close()
+	}
+}
+
+// remove kills anything left running in the cgroup and removes it.  A
+// cgroup can only be removed once all its processes are gone, which takes a
+// moment after they're killed.
+func (d *cgroupDir) remove() {
+	if d == nil {
+		return
+	}
+	killed := ioutil.WriteFile(filepath.Join(d.path, "cgroup.kill"), []byte("1"), 0644) == nil
+	deadline := time.Now().Add(time.Second)
+	for os.Remove(d.path) != nil && time.Now().Before(deadline) {
+		if !killed {
+			// cgroup.kill needs Linux 5.14, so on older kernels the
+			// processes are killed in turn, until none are left to start
+			// more.
+			d.killProcs()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// killProcs kills every process listed in the cgroup's cgroup.procs.
+func (d *cgroupDir) killProcs() {
+	b, err := ioutil.ReadFile(filepath.Join(d.path, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	for _, field := range strings.Fields(string(b)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
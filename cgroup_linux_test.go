@@ -0,0 +1,83 @@
+package pie
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCgroupLimit(t *testing.T) {
+	d := &cgroupDir{path: t.TempDir()}
+	if err := d.limit(Cgroup{MemoryMax: 1 << 20, CPUMax: 0.5}); err != nil {
+		t.Fatalf("Unexpected error from limit: %#v", err)
+	}
+	for name, expected := range map[string]string{
+		"memory.max": "1048576",
+		"cpu.max":    "50000 100000",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(d.path, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("Expected %s to be %q, got %q", name, expected, b)
+		}
+	}
+}
+
+func TestWithCgroup(t *testing.T) {
+	parent := "/sys/fs/cgroup"
+	b, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	if err != nil || !strings.Contains(string(b), "memory") {
+		t.Skip("no cgroup v2 with the memory controller enabled")
+	}
+	t.Setenv(helperEnv, "1")
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"),
+		WithCgroup(Cgroup{Parent: parent, MemoryMax: 1 << 30}))
+	if err != nil {
+		t.Skipf("can't start plugin in a cgroup: %s", err)
+	}
+	b, err = ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(p.PID()), "cgroup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cgroup string
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			cgroup = strings.TrimPrefix(line, "0::")
+		}
+	}
+	if !strings.HasPrefix(filepath.Base(cgroup), "pie-") {
+		t.Errorf("Expected plugin to run in a pie cgroup, got %q", cgroup)
+	}
+	p.Close()
+	if !eventually(func() bool {
+		_, err := os.Stat(filepath.Join(parent, cgroup))
+		return os.IsNotExist(err)
+	}) {
+		t.Errorf("Expected cgroup %s to be removed", cgroup)
+	}
+}
+
+func TestCgroupKillProcs(t *testing.T) {
+	// Without cgroup.kill, the processes listed in cgroup.procs are killed.
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't start sleep: %s", err)
+	}
+	d := &cgroupDir{path: t.TempDir()}
+	procs := strconv.Itoa(cmd.Process.Pid) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(d.path, "cgroup.procs"), []byte(procs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.killProcs()
+	cmd.Wait()
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || ws.Signal() != syscall.SIGKILL {
+		t.Errorf("Expected process to be killed, got %s", cmd.ProcessState)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package pie
+
+import "errors"
+
+// cgroupDir is never created on this system.
+type cgroupDir struct{}
+
+// newCgroup returns an error, because cgroups are only supported on Linux.
+func newCgroup(c execCmd, cg Cgroup) (*cgroupDir, error) {
+	return nil, errors.New("cgroups are only supported on linux")
+}
+
+func (d *cgroupDir) started() {}
+
+func (d *cgroupDir) remove() {}
@@ -0,0 +1,62 @@
+package pie
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+	"reflect"
+)
+
+// StartProviderContext is like StartProvider, but the plugin application is
+// stopped if ctx is done before the plugin exits on its own.  The plugin is
+// stopped the same way closing the Plugin stops it, by interrupting the
+// process and then killing it if it doesn't exit in time.  The Plugin must
+// still be closed to release its resources.
+func StartProviderContext(ctx context.Context, output io.Writer, path string, args ...string) (*Plugin, error) {
+	return StartProviderWith(output, path, args, WithContext(ctx))
+}
+
+// StartConsumerContext is like StartConsumer, but the plugin application is
+// stopped if ctx is done before the plugin exits on its own.  The plugin is
+// stopped the same way closing the Server stops it, by interrupting the
+// process and then killing it if it doesn't exit in time.
+func StartConsumerContext(ctx context.Context, output io.Writer, path string, args ...string) (Server, error) {
+	return StartConsumerWith(output, path, args, WithContext(ctx))
+}
+
+// Caller is an RPC client that can make asynchronous calls.  It is implemented
+// by *rpc.Client, and so by *Plugin and the clients returned by NewConsumer.
+type Caller interface {
+	Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call
+}
+
+// Call invokes the named function using c, and waits for it to complete or for
+// ctx to be done, whichever happens first.  If ctx is done first, Call returns
+// ctx.Err() without waiting for the reply, and reply is left untouched.  Note
+// that this only abandons the call on the client side; the function being
+// called keeps running until it returns.
+func Call(ctx context.Context, c Caller, serviceMethod string, args interface{}, reply interface{}) error {
+	// Decode into a fresh value so that a reply arriving after we've given up
+	// can't race with the caller's use of reply.
+	tmp := reply
+	rv := reflect.ValueOf(reply)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		tmp = reflect.New(rv.Type().Elem()).Interface()
+	}
+	call := c.Go(serviceMethod, args, tmp, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if p, ok := c.(*Plugin); ok && call.Error != nil {
+			return p.callErr(serviceMethod, call.Error)
+		}
+		if call.Error != nil {
+			return call.Error
+		}
+		if tmp != reply {
+			rv.Elem().Set(reflect.ValueOf(tmp).Elem())
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
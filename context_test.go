@@ -0,0 +1,116 @@
+package pie
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStartProviderContextCancel(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := StartProviderContext(ctx, os.Stderr, os.Args[0], helperArgs("provider")...)
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderContext: %#v", err)
+	}
+	defer p.Close()
+
+	var pid int
+	if err := p.Call("helper.PID", 0, &pid); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	cancel()
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Plugin not stopped after context was canceled")
+	}
+	if err := p.Err(); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled from Err, got %#v", err)
+	}
+}
+
+func TestStartProviderContextAlreadyDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f := &fakeCmdData{p: &proc{}}
+	old := makeCommand
+	makeCommand = f.makeCommand
+	defer func() { makeCommand = old }()
+
+	if _, err := StartProviderContext(ctx, nil, "foo"); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled from StartProviderContext, got %#v", err)
+	}
+	if f.p.waited {
+		t.Fatal("Process started even though the context was already done")
+	}
+}
+
+func TestStartConsumerContextCancel(t *testing.T) {
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	defer stdinR.Close()
+	defer stdoutW.Close()
+	process := &proc{running: make(chan struct{})}
+	f := &fakeCmdData{
+		stdout: stdoutR,
+		stdin:  stdinW,
+		p:      process,
+	}
+	old := makeCommand
+	makeCommand = f.makeCommand
+	defer func() { makeCommand = old }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := StartConsumerContext(ctx, &bytes.Buffer{}, "foo")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartConsumerContext: %#v", err)
+	}
+	defer s.Close()
+	cancel()
+	select {
+	case <-s.rwc.(ioPipe).exit.done:
+	case <-time.After(time.Second):
+		t.Fatal("Plugin not stopped after context was canceled")
+	}
+	if process.sig != os.Interrupt {
+		t.Errorf("Expected process to be sent os.Interrupt, got %#v", process.sig)
+	}
+}
+
+func TestCallContextTimeout(t *testing.T) {
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error starting plugin: %#v", err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := Call(ctx, p, "helper.Sleep", time.Minute, nil); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded from Call, got %#v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("Call did not return promptly after the context was done, took %s", d)
+	}
+}
+
+func TestCallContextReply(t *testing.T) {
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error starting plugin: %#v", err)
+	}
+	defer p.Close()
+
+	var reply string
+	if err := Call(context.Background(), p, "api.SayHi", "bob", &reply); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if reply != "Hi bob" {
+		t.Fatalf("Wrong reply from Call, expected %q, got %q", "Hi bob", reply)
+	}
+}
@@ -0,0 +1,158 @@
+package pie
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// These errors describe why starting, talking to, or stopping a plugin
+// application failed.  The errors returned by this package wrap them, so use
+// errors.Is to check for them.
+var (
+	// ErrPluginNotFound means the plugin executable doesn't exist or couldn't
+	// be found in $PATH.
+	ErrPluginNotFound = errors.New("plugin not found")
+
+	// ErrPluginExited means the plugin application exited without being
+	// stopped by the host.  The error is an *ExitError.
+	ErrPluginExited = errors.New("plugin exited")
+
+	// ErrClosed is returned by Plugin.Err when the plugin application exited
+	// because the host closed the Plugin.
+	ErrClosed = errors.New("plugin closed by host")
+
+	// ErrStopTimeout means the plugin application didn't exit after being
+	// signalled to stop, and had to be killed.  The error is a
+	// *StopTimeoutError.
+	ErrStopTimeout = errors.New("process killed after timeout waiting for process to stop")
+
+	// ErrUntrustedPlugin means the plugin executable failed one of the checks
+	// requested using WithSHA256, WithAllowlist or WithSignature, and wasn't
+	// started.
+	ErrUntrustedPlugin = errors.New("plugin failed verification")
+
+	// ErrHandshakeFailed means the handshake requested using WithHandshake
+	// didn't complete.  The error is a *HandshakeError.
+	ErrHandshakeFailed = errors.New("plugin handshake failed")
+
+	// ErrIncompatiblePlugin means the plugin answered the handshake with a
+	// magic cookie, protocol version or codec that doesn't match the host's,
+	// or didn't answer it properly at all.  It usually means the wrong
+	// executable was started.  The error is a *HandshakeError, and also
+	// matches ErrHandshakeFailed.
+	ErrIncompatiblePlugin = errors.New("incompatible plugin")
+)
+
+// notFound wraps err, an error from starting a process, with
+// ErrPluginNotFound if it means the executable doesn't exist.
+func notFound(err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrPluginNotFound, err)
+	}
+	return err
+}
+
+// ExitError describes a plugin application that exited on its own.  It
+// matches ErrPluginExited.
+type ExitError struct {
+	// State is the exited process' state, if known.
+	State *os.ProcessState
+	// Err is the error from waiting for the process, if any.
+	Err error
+	// Stderr is the last few lines the plugin wrote to stderr.
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	var msg string
+	switch {
+	case e.Err != nil:
+		msg = "error waiting for plugin to exit: " + e.Err.Error()
+	case e.State != nil:
+		msg = "plugin exited unexpectedly: " + e.State.String()
+	default:
+		msg = "plugin exited unexpectedly"
+	}
+	return withStderr(msg, e.Stderr)
+}
+
+// Unwrap returns the error from waiting for the process, if any.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrPluginExited.
+func (e *ExitError) Is(target error) bool {
+	return target == ErrPluginExited
+}
+
+// StopTimeoutError is returned when a plugin application had to be killed
+// because it didn't exit after any of its stop steps.  It matches
+// ErrStopTimeout.
+type StopTimeoutError struct {
+	// Steps are the stop steps that were taken before the process was
+	// killed.
+	Steps []StopStep
+	// Stderr is the last few lines the plugin wrote to stderr.
+	Stderr string
+}
+
+func (e *StopTimeoutError) Error() string {
+	steps := make([]string, len(e.Steps))
+	for i, step := range e.Steps {
+		steps[i] = fmt.Sprintf("%s then waited %s", step.Signal, step.Wait)
+	}
+	msg := fmt.Sprintf("%s (%s)", ErrStopTimeout, strings.Join(steps, ", "))
+	return withStderr(msg, e.Stderr)
+}
+
+// Is reports whether target is ErrStopTimeout.
+func (e *StopTimeoutError) Is(target error) bool {
+	return target == ErrStopTimeout
+}
+
+// withStderr adds the plugin's last lines of stderr, if any, to msg.
+func withStderr(msg, stderr string) string {
+	if stderr == "" {
+		return msg
+	}
+	return msg + "; last stderr:\n" + stderr
+}
+
+// HandshakeError describes a failed handshake.  It matches ErrHandshakeFailed,
+// and also ErrIncompatiblePlugin if the plugin isn't compatible with the host.
+type HandshakeError struct {
+	// Reason says what went wrong.
+	Reason string
+	// Incompatible is true if the plugin answered the handshake in a way
+	// that shows it isn't compatible with the host, or didn't answer it at
+	// all.
+	Incompatible bool
+	// Err is the error from talking to the plugin, if any.
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	prefix := ErrHandshakeFailed.Error()
+	if e.Incompatible {
+		prefix = ErrIncompatiblePlugin.Error()
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %s", prefix, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", prefix, e.Reason)
+}
+
+// Unwrap returns the error from talking to the plugin, if any.
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrHandshakeFailed, or ErrIncompatiblePlugin
+// for an incompatible plugin.
+func (e *HandshakeError) Is(target error) bool {
+	return target == ErrHandshakeFailed || (e.Incompatible && target == ErrIncompatiblePlugin)
+}
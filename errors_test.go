@@ -0,0 +1,79 @@
+package pie
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStartNotFound(t *testing.T) {
+	for name, path := range map[string]string{
+		"path": filepath.Join(t.TempDir(), "no-such-plugin"),
+		"name": "pie-no-such-plugin",
+	} {
+		_, err := StartProvider(os.Stderr, path)
+		if !errors.Is(err, ErrPluginNotFound) {
+			t.Errorf("%s: expected ErrPluginNotFound, got %#v", name, err)
+		}
+	}
+}
+
+func TestExitError(t *testing.T) {
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	defer p.Close()
+
+	p.Call("helper.Exit", 1, nil)
+	<-p.Done()
+	err = p.Err()
+	if !errors.Is(err, ErrPluginExited) {
+		t.Fatalf("Expected ErrPluginExited from Err, got %#v", err)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Expected *ExitError from Err, got %#v", err)
+	}
+	if exitErr.State == nil || exitErr.State.ExitCode() != 1 {
+		t.Errorf("Expected exit code 1, got %v", exitErr.State)
+	}
+}
+
+func TestClosedIsNotExitError(t *testing.T) {
+	p := &proc{running: make(chan struct{})}
+	pipe := newIOPipe(idleReader(), nopWCloser{ioutil.Discard}, p)
+	plugin := newPlugin(rpc.NewClient(pipe), pipe)
+	plugin.Close()
+	if err := plugin.Err(); errors.Is(err, ErrPluginExited) {
+		t.Fatalf("Expected plugin closed by host not to match ErrPluginExited, got %#v", err)
+	}
+}
+
+func TestStopTimeoutError(t *testing.T) {
+	p := &signalProc{proc: proc{running: make(chan struct{})}}
+	iop := newIOPipe(&closeRW{}, &closeRW{}, p)
+	iop.stop = []StopStep{{Signal: os.Interrupt, Wait: time.Millisecond}}
+	var stopErr *StopTimeoutError
+	if err := iop.Close(); !errors.As(err, &stopErr) {
+		t.Fatalf("Expected *StopTimeoutError from Close, got %#v", err)
+	}
+	if len(stopErr.Steps) != 1 || stopErr.Steps[0] != iop.stop[0] {
+		t.Errorf("Expected error to report steps %v, got %v", iop.stop, stopErr.Steps)
+	}
+}
+
+func TestHandshakeErrorIs(t *testing.T) {
+	err := error(&HandshakeError{Reason: "test", Err: errors.New("broken pipe")})
+	if !errors.Is(err, ErrHandshakeFailed) || errors.Is(err, ErrIncompatiblePlugin) {
+		t.Errorf("Expected only ErrHandshakeFailed to match %#v", err)
+	}
+	err = &HandshakeError{Reason: "test", Incompatible: true}
+	if !errors.Is(err, ErrHandshakeFailed) || !errors.Is(err, ErrIncompatiblePlugin) {
+		t.Errorf("Expected ErrHandshakeFailed and ErrIncompatiblePlugin to match %#v", err)
+	}
+}
@@ -1,9 +1,11 @@
 package pie_test
 
 import (
+	"context"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"net/rpc/jsonrpc"
 
@@ -32,7 +34,7 @@ func ExampleStartProviderCodec() {
 // This example shows the plugin starting a JSON-RPC server to be accessed by
 // the master program. Server.ServeCodec() will block forever, so it is common
 // to simply put this at the end of the plugin's main function.
-func ExampleProvider_ServeCodec() {
+func ExampleServer_ServeCodec() {
 	p := pie.NewProvider()
 	if err := p.RegisterName("Foo", API{}); err != nil {
 		log.Fatalf("can't register api: %s", err)
@@ -51,6 +53,21 @@ func ExampleNewConsumerCodec() {
 	client.Call("Foo.ToUpper", "something", &reply)
 }
 
+// This function should be called from a plugin program that may be busy when
+// the master program goes away.
+//
+// This example shows the plugin handing the Lifecycle's context to its API, so
+// that long calls can give up once the master program is done with the
+// plugin.  Lifecycle.Serve exits the plugin once those calls have returned.
+func ExampleLifecycle() {
+	l := pie.NewLifecycle(0)
+	p := pie.NewProvider()
+	if err := p.RegisterName("Slow", SlowAPI{ctx: l.Context()}); err != nil {
+		log.Fatalf("can't register api: %s", err)
+	}
+	l.Serve(p)
+}
+
 // API is an example type to show how to serve methods over RPC.
 type API struct{}
 
@@ -60,3 +77,20 @@ func (API) ToUpper(input string, output *string) error {
 	*output = strings.ToUpper(input)
 	return nil
 }
+
+// SlowAPI is an example type whose methods take a while, unless the plugin is
+// stopping.
+type SlowAPI struct {
+	ctx context.Context
+}
+
+// Wait is an example function that waits for the given duration, or until the
+// plugin is stopping.
+func (a SlowAPI) Wait(d time.Duration, _ *int) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-a.ctx.Done():
+		return a.ctx.Err()
+	}
+}
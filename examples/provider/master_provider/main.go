@@ -7,7 +7,6 @@ package main
 
 import (
 	"log"
-	"net/rpc"
 	"net/rpc/jsonrpc"
 	"os"
 	"runtime"
@@ -44,7 +43,7 @@ func main() {
 }
 
 type plug struct {
-	client *rpc.Client
+	client *pie.Plugin
 }
 
 func (p plug) SayHi(name string) (result string, err error) {
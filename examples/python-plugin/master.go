@@ -3,8 +3,6 @@ package main
 import (
 	"fmt"
 	"log"
-	"net/rpc"
-	"net/rpc/jsonrpc"
 	"os"
 	"time"
 
@@ -12,17 +10,25 @@ import (
 )
 
 var max int = 2000
-var path string = "./plugin.py"
+var manifest string = "adder.plugin.json"
 
 type plug struct {
-	Client *rpc.Client
+	Client *pie.Pool
 }
 
 func createClient() *plug {
 	log.Printf("Creating plugin")
-	client, err := pie.StartProviderCodec(jsonrpc.NewClientCodec, os.Stderr, path)
+	m, err := pie.ReadManifest(manifest)
 	if err != nil {
-		log.Printf("Create error: %v", err)
+		log.Fatalf("Manifest error: %v", err)
+	}
+	// Spread the calls over up to four copies of the plugin, starting more
+	// when the ones running have calls queued up.
+	client, err := pie.NewPool(func() (*pie.Plugin, error) {
+		return m.StartProvider(os.Stderr)
+	}, pie.PoolConfig{Min: 1, Max: 4, QueueDepth: 8})
+	if err != nil {
+		log.Fatalf("Create error: %v", err)
 	}
 	p := &plug{client}
 	return p
@@ -3,7 +3,7 @@
 # Description: A sample asynchronous RPC server plugin over STDIO in python that works with natefiinch/pie
 # Usage:
 #   pip install pyjsonrpc
-#   go run master.go
+#   go run master.go (which starts this plugin as described by adder.plugin.json)
 
 from __future__ import print_function
 import sys
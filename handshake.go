@@ -0,0 +1,231 @@
+package pie
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// handshakeEnv tells a plugin application that its host will start by sending
+// a handshake.
+const handshakeEnv = "PIE_HANDSHAKE"
+
+// handshakeTimeout is how long a host waits for a plugin to answer the
+// handshake, unless the Handshake says otherwise.
+var handshakeTimeout = 10 * time.Second
+
+// maxHandshakeLine is the longest handshake message that will be read, so that
+// a plugin that isn't speaking the handshake can't make the host read forever.
+const maxHandshakeLine = 64 * 1024
+
+// errBadMessage means a handshake message couldn't be understood.
+var errBadMessage = errors.New("malformed handshake message")
+
+// Handshake is what the host and plugin applications must agree on before they
+// start talking RPC.  Empty or zero fields aren't checked.
+type Handshake struct {
+	// MagicCookie is a value known to the host and to the plugins written for
+	// it, which makes it unlikely that some other executable is mistaken for
+	// a plugin.
+	MagicCookie string `json:"cookie,omitempty"`
+	// ProtocolVersion is the version of the API the plugin serves or
+	// consumes.
+	ProtocolVersion int `json:"version,omitempty"`
+	// Codec is the name of the RPC codec, such as "gob" or "jsonrpc".  The
+	// host fills in "gob" for a provider started without WithCodec, and
+	// plugins fill in "gob" when they call Serve or NewConsumer.
+	Codec string `json:"codec,omitempty"`
+	// Timeout is how long the host waits for the plugin to answer.  The
+	// default is ten seconds.
+	Timeout time.Duration `json:"-"`
+}
+
+// PluginInfo is how a plugin application describes itself during the
+// handshake.
+type PluginInfo struct {
+	Name string `json:"name,omitempty"`
+	Handshake
+	// Services are the names of the services the plugin registered, filled
+	// in by the Server.  It is empty for consumer plugins.
+	Services []string `json:"services,omitempty"`
+}
+
+// handshakeReply is the plugin's answer to the host's handshake.
+type handshakeReply struct {
+	PluginInfo
+	Error string `json:"error,omitempty"`
+}
+
+// WithHandshake makes the host send h to the plugin application as soon as it
+// starts, and wait for the plugin to answer with its own PluginInfo.  If the
+// plugin doesn't answer in time, or its answer doesn't match h, the plugin is
+// stopped and the Start function returns a *HandshakeError that matches
+// ErrIncompatiblePlugin.  If talking to the plugin fails, for instance because
+// it exits before answering, the error only matches ErrHandshakeFailed.
+// Plugins answer automatically when they call NewConsumer or Server.Serve,
+// using the PluginInfo given to SetPluginInfo.
+func WithHandshake(h Handshake) Option {
+	return func(cfg *config) { cfg.handshake = &h }
+}
+
+var (
+	infoMu     sync.Mutex
+	pluginInfo PluginInfo
+)
+
+// SetPluginInfo sets how this plugin application describes itself to its host
+// during the handshake, and what it expects the host to send.  It should be
+// called by the plugin before NewConsumer or Server.Serve.
+func SetPluginInfo(info PluginInfo) {
+	infoMu.Lock()
+	defer infoMu.Unlock()
+	pluginInfo = info
+}
+
+// Info returns how the plugin application described itself during the
+// handshake.  It is empty if the plugin wasn't started using WithHandshake.
+func (p *Plugin) Info() PluginInfo {
+	return p.info
+}
+
+// shake performs the host's side of the handshake over pipe.
+func shake(pipe ioPipe, hello Handshake) (PluginInfo, error) {
+	timeout := hello.Timeout
+	if timeout <= 0 {
+		timeout = handshakeTimeout
+	}
+	type result struct {
+		reply handshakeReply
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		if r.err = writeMessage(pipe, hello); r.err == nil {
+			r.err = readMessage(pipe, &r.reply)
+		}
+		done <- r
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(timeout):
+		return PluginInfo{}, &HandshakeError{
+			Reason:       fmt.Sprintf("no answer after %s", timeout),
+			Incompatible: true,
+		}
+	}
+	if r.err != nil {
+		return PluginInfo{}, &HandshakeError{
+			Reason:       "can't talk to plugin",
+			Incompatible: errors.Is(r.err, errBadMessage),
+			Err:          r.err,
+		}
+	}
+	if r.reply.Error != "" {
+		return PluginInfo{}, &HandshakeError{
+			Reason:       "plugin rejected handshake: " + r.reply.Error,
+			Incompatible: true,
+		}
+	}
+	if err := hello.check(r.reply.Handshake); err != nil {
+		return PluginInfo{}, &HandshakeError{Reason: err.Error(), Incompatible: true}
+	}
+	return r.reply.PluginInfo, nil
+}
+
+// answerHandshake performs the plugin's side of the handshake over rwc, if
+// the host asked for one.  The codec and services describe the plugin's end
+// of the connection.
+func answerHandshake(rwc io.ReadWriter, codec string, services []string) error {
+	if os.Getenv(handshakeEnv) == "" {
+		return nil
+	}
+	os.Unsetenv(handshakeEnv)
+
+	var hello Handshake
+	if err := readMessage(rwc, &hello); err != nil {
+		return fmt.Errorf("can't read handshake from host: %s", err)
+	}
+	infoMu.Lock()
+	reply := handshakeReply{PluginInfo: pluginInfo}
+	infoMu.Unlock()
+	if reply.Codec == "" {
+		reply.Codec = codec
+	}
+	reply.Services = services
+	err := reply.check(hello)
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	if writeErr := writeMessage(rwc, reply); writeErr != nil {
+		return fmt.Errorf("can't answer handshake from host: %s", writeErr)
+	}
+	return err
+}
+
+// check returns an error if other doesn't match h.
+func (h Handshake) check(other Handshake) error {
+	switch {
+	case h.MagicCookie != "" && other.MagicCookie != "" && h.MagicCookie != other.MagicCookie:
+		return errors.New("magic cookie mismatch")
+	case h.MagicCookie != "" && other.MagicCookie == "":
+		return errors.New("no magic cookie")
+	case h.ProtocolVersion != 0 && other.ProtocolVersion != 0 && h.ProtocolVersion != other.ProtocolVersion:
+		return fmt.Errorf("protocol version %d does not match %d", other.ProtocolVersion, h.ProtocolVersion)
+	case h.Codec != "" && other.Codec != "" && h.Codec != other.Codec:
+		return fmt.Errorf("codec %q does not match %q", other.Codec, h.Codec)
+	}
+	return nil
+}
+
+// logHandshake logs a failed handshake on the plugin side, where there's no
+// caller to return it to.
+func logHandshake(err error) {
+	if err != nil {
+		log.Printf("pie: %s", err)
+	}
+}
+
+// writeMessage writes v to w as a single line of JSON.
+func writeMessage(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// readMessage reads a single line of JSON from r into v.  It reads a byte at a
+// time, so that it doesn't consume any of the RPC traffic that follows.
+func readMessage(r io.Reader, v interface{}) error {
+	var line bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if line.Len() >= maxHandshakeLine {
+			return fmt.Errorf("%w: too long", errBadMessage)
+		}
+		line.WriteByte(b[0])
+	}
+	if err := json.Unmarshal(line.Bytes(), v); err != nil {
+		return fmt.Errorf("%w: %s", errBadMessage, err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package pie
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("handshake", "helper", "cookie", "1"),
+		WithEnv(helperEnv+"=1"),
+		WithHandshake(Handshake{MagicCookie: "cookie", ProtocolVersion: 1}))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	defer p.Close()
+
+	info := p.Info()
+	if info.Name != "helper" {
+		t.Errorf("Expected plugin name %q, got %q", "helper", info.Name)
+	}
+	if info.ProtocolVersion != 1 {
+		t.Errorf("Expected protocol version 1, got %d", info.ProtocolVersion)
+	}
+	if info.Codec != "gob" {
+		t.Errorf("Expected codec %q, got %q", "gob", info.Codec)
+	}
+	services := append([]string(nil), info.Services...)
+	sort.Strings(services)
+	if expected := []string{"API2", "api", "helper", "streams"}; !reflect.DeepEqual(services, expected) {
+		t.Errorf("Expected services %v, got %v", expected, services)
+	}
+	var response string
+	if err := p.Call("api.SayHi", "bob", &response); err != nil {
+		t.Fatalf("Unexpected error from Call after handshake: %#v", err)
+	}
+	if response != "Hi bob" {
+		t.Fatalf("Wrong response from api call, expected %q, got %q", "Hi bob", response)
+	}
+}
+
+func TestHandshakeMismatch(t *testing.T) {
+	for name, hello := range map[string]Handshake{
+		"version": {MagicCookie: "cookie", ProtocolVersion: 2},
+		"cookie":  {MagicCookie: "other", ProtocolVersion: 1},
+		"codec":   {MagicCookie: "cookie", ProtocolVersion: 1, Codec: "jsonrpc"},
+	} {
+		_, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("handshake", "helper", "cookie", "1"),
+			WithEnv(helperEnv+"=1"), WithHandshake(hello))
+		if !errors.Is(err, ErrIncompatiblePlugin) {
+			t.Errorf("%s: expected ErrIncompatiblePlugin, got %#v", name, err)
+		}
+	}
+}
+
+func TestHandshakeNotAPlugin(t *testing.T) {
+	for name, test := range map[string]struct {
+		args         []string
+		incompatible bool
+	}{
+		"garbage": {[]string{"-c", "echo hello; exec sleep 10"}, true},
+		"exits":   {[]string{"-c", "exit 0"}, false},
+		"silent":  {[]string{"-c", "exec sleep 10"}, true},
+	} {
+		start := time.Now()
+		_, err := StartProviderWith(os.Stderr, "sh", test.args,
+			WithHandshake(Handshake{MagicCookie: "cookie", Timeout: 100 * time.Millisecond}))
+		if !errors.Is(err, ErrHandshakeFailed) {
+			t.Errorf("%s: expected ErrHandshakeFailed, got %#v", name, err)
+		}
+		if errors.Is(err, ErrIncompatiblePlugin) != test.incompatible {
+			t.Errorf("%s: expected ErrIncompatiblePlugin to be %v, got %#v", name, test.incompatible, err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: handshake took too long to fail: %s", name, d)
+		}
+	}
+}
+
+func TestHandshakeConsumer(t *testing.T) {
+	s, err := StartConsumerWith(os.Stderr, os.Args[0], helperArgs("consumer"),
+		WithEnv(helperEnv+"=1"), WithHandshake(Handshake{Codec: "gob"}))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartConsumerWith: %#v", err)
+	}
+	api := recordAPI{make(chan string, 1)}
+	s.RegisterName("api", api)
+
+	serveConsumer(t, s)
+	select {
+	case <-api.called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Consumer plugin did not call the host after the handshake")
+	}
+}
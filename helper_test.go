@@ -0,0 +1,185 @@
+package pie
+
+import (
+	"fmt"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// helperEnv is set in the environment of plugin applications started by
+// startHelper, so that TestHelperProcess knows to act as a plugin.
+const helperEnv = "PIE_WANT_HELPER_PROCESS"
+
+func init() {
+	// Tests that call NewProvider or NewConsumer in this process shouldn't
+	// redirect the test binary's own stdout, but plugin applications started
+	// by tests should.
+	if os.Getenv(helperEnv) != "1" {
+		stdoutOnce.Do(func() { stdoutFile = os.Stdout })
+		// Plugin applications built with the race detector otherwise wait a
+		// second before exiting, which is as long as Close waits for them.
+		if os.Getenv("GORACE") == "" {
+			os.Setenv("GORACE", "atexit_sleep_ms=0")
+		}
+	}
+}
+
+// startHelper starts this test binary as a provider plugin application that
+// runs TestHelperProcess in the given mode.
+func startHelper(t *testing.T, mode string, args ...string) (*Plugin, error) {
+	t.Setenv(helperEnv, "1")
+	return StartProvider(os.Stderr, os.Args[0], helperArgs(mode, args...)...)
+}
+
+// helperArgs returns the command line arguments that make this test binary run
+// TestHelperProcess in the given mode.
+func helperArgs(mode string, args ...string) []string {
+	return append([]string{"-test.run=^TestHelperProcess$", "--", mode}, args...)
+}
+
+// serveConsumer serves the host's side of a consumer plugin until the test is
+// over, and then closes it and waits for serving to stop, so that the plugin
+// has been stopped before the next test starts.
+func serveConsumer(t *testing.T, s Server) {
+	served := make(chan struct{})
+	go func() {
+		s.Serve()
+		close(served)
+	}()
+	t.Cleanup(func() {
+		s.Close()
+		<-served
+	})
+}
+
+// TestHelperProcess isn't a real test.  It's run as a plugin application by
+// other tests, which start this test binary with helperEnv set.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "no helper mode given")
+		os.Exit(2)
+	}
+	switch mode := args[1]; mode {
+	case "provider":
+		serveHelper()
+	case "jsonrpc":
+		helperProvider().ServeCodec(jsonrpc.NewServerCodec)
+	case "handshake":
+		// handshake <name> <cookie> <version>
+		version, _ := strconv.Atoi(args[4])
+		SetPluginInfo(PluginInfo{
+			Name:      args[2],
+			Handshake: Handshake{MagicCookie: args[3], ProtocolVersion: version},
+		})
+		serveHelper()
+	case "consumer":
+		c := NewConsumer()
+		var reply string
+		if err := c.Call("api.SayHi", "plugin", &reply); err != nil {
+			fmt.Fprintf(os.Stderr, "error calling host: %s\n", err)
+			os.Exit(1)
+		}
+		c.Close()
+	case "lifecycle":
+		serveLifecycle()
+	case "bidirectional":
+		serveBidirectional()
+	case "ignore-interrupt":
+		// Act like a plugin that is slow to shut down, so that it has to be
+		// stopped by something other than an interrupt.
+		signal.Ignore(os.Interrupt)
+		serveHelper()
+		time.Sleep(time.Hour)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown helper mode %q\n", mode)
+		os.Exit(2)
+	}
+}
+
+// serveHelper serves the test APIs as a provider plugin.
+func serveHelper() {
+	helperProvider().Serve()
+}
+
+// helperProvider returns a provider with the test APIs registered.
+func helperProvider() Server {
+	p := NewProvider()
+	p.RegisterName("api", api{})
+	p.Register(API2{})
+	p.RegisterName("helper", helper{})
+	p.RegisterName("streams", streamer{p.Session()})
+	return p
+}
+
+// helper is an API served by TestHelperProcess that lets tests control the
+// plugin application.
+type helper struct{}
+
+// Exit exits the plugin application with the given exit code.
+func (helper) Exit(code int, _ *int) error {
+	os.Exit(code)
+	return nil
+}
+
+// Fail writes msg to the plugin application's stderr and exits with code 3.
+func (helper) Fail(msg string, _ *int) error {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(3)
+	return nil
+}
+
+// Sleep waits for the given duration before returning.
+func (helper) Sleep(d time.Duration, _ *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+// PID returns the process id of the plugin application.
+func (helper) PID(_ int, pid *int) error {
+	*pid = os.Getpid()
+	return nil
+}
+
+// Getenv returns the value of the plugin application's environment variable.
+func (helper) Getenv(key string, value *string) error {
+	*value = os.Getenv(key)
+	return nil
+}
+
+// Getwd returns the plugin application's working directory.
+func (helper) Getwd(_ int, dir *string) error {
+	var err error
+	*dir, err = os.Getwd()
+	return err
+}
+
+// Print writes s to the plugin application's stdout.
+func (helper) Print(s string, _ *int) error {
+	_, err := fmt.Println(s)
+	return err
+}
+
+// eventually reports whether f returns true within a few seconds.
+func eventually(f func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package pie
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock constants that the syscall package doesn't define.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+
+	oPath = 0x200000
+)
+
+// Landlock's filesystem access rights.
+const (
+	llExecute = 1 << iota
+	llWriteFile
+	llReadFile
+	llReadDir
+	llRemoveDir
+	llRemoveFile
+	llMakeChar
+	llMakeDir
+	llMakeReg
+	llMakeSock
+	llMakeFifo
+	llMakeBlock
+	llMakeSym
+	llRefer
+	llTruncate
+	llIoctlDev
+)
+
+// llRead are the rights given to the paths a plugin may read.
+const llRead = llExecute | llReadFile | llReadDir
+
+// llFile are the rights that apply to files, as opposed to directories.
+const llFile = llExecute | llWriteFile | llReadFile | llTruncate | llIoctlDev
+
+// newLandlockRuleset returns the Landlock ruleset that enforces fa, denying
+// every right the kernel knows about unless fa allows it.
+func newLandlockRuleset(fa *FileAccess) (*landlockRuleset, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	switch errno {
+	case 0:
+	case syscall.ENOSYS:
+		return nil, fmt.Errorf("%w: kernel doesn't support Landlock", errors.ErrUnsupported)
+	case syscall.EOPNOTSUPP:
+		return nil, fmt.Errorf("%w: Landlock is disabled in the kernel", errors.ErrUnsupported)
+	default:
+		return nil, fmt.Errorf("can't check Landlock support: %w", errno)
+	}
+	rs := &landlockRuleset{Handled: llMakeSym<<1 - 1}
+	if abi >= 2 {
+		rs.Handled |= llRefer
+	}
+	if abi >= 3 {
+		rs.Handled |= llTruncate
+	}
+	if abi >= 5 {
+		rs.Handled |= llIoctlDev
+	}
+	for _, path := range fa.Read {
+		rs.Rules = append(rs.Rules, landlockRule{Path: path, Access: llRead})
+	}
+	for _, path := range fa.Write {
+		rs.Rules = append(rs.Rules, landlockRule{Path: path, Access: rs.Handled})
+	}
+	return rs, nil
+}
+
+// enforce restricts the plugin executed by this thread to the files allowed
+// by rs, and lets it execute exe.
+func (rs *landlockRuleset) enforce(exe string) error {
+	attr := rs.Handled
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("can't create Landlock ruleset: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+	rules := append(rs.Rules, landlockRule{Path: exe, Access: llExecute | llReadFile})
+	for _, rule := range rules {
+		if err := rule.add(int(fd), rs.Handled); err != nil {
+			return err
+		}
+	}
+	if err := noNewPrivs(); err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("can't enforce Landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// add adds the rule to the given ruleset.  Rights that the ruleset doesn't
+// handle, or that don't apply to the kind of file the rule is for, are left
+// out.
+func (rule landlockRule) add(ruleset int, handled uint64) error {
+	fd, err := syscall.Open(rule.Path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("can't allow access to %s: %w", rule.Path, err)
+	}
+	defer syscall.Close(fd)
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("can't allow access to %s: %w", rule.Path, err)
+	}
+	access := rule.Access & handled
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= llFile
+	}
+	if access == 0 {
+		return nil
+	}
+	// struct landlock_path_beneath_attr is packed, so it's built by hand.
+	var attr [12]byte
+	binary.NativeEndian.PutUint64(attr[:8], access)
+	binary.NativeEndian.PutUint32(attr[8:], uint32(fd))
+	_, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr[0])), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("can't allow access to %s: %w", rule.Path, errno)
+	}
+	return nil
+}
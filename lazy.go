@@ -0,0 +1,208 @@
+package pie
+
+import (
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// LazyPlugin is a handle to a provider plugin application that isn't started
+// until it is first called, and is stopped again once it has gone without
+// calls for a while.  The next call starts it again.  It suits hosts with many
+// plugins that are rarely used, which would otherwise all keep running.
+//
+// The plugin is stopped the same way closing a Plugin stops it.  If it exits
+// on its own, the next call starts it again.
+type LazyPlugin struct {
+	start StartFunc
+	idle  time.Duration
+
+	mu     sync.Mutex
+	plugin *Plugin
+	// calls is how many calls are in progress.
+	calls int
+	timer *time.Timer
+	// gen changes whenever a call starts or finishes, so that an idle timer
+	// that fires late can tell it is out of date.
+	gen    int
+	closed bool
+	// starting is closed once the plugin being started has started, or
+	// failed to.  It is nil while no plugin is being started.
+	starting chan struct{}
+	// stopping tracks the plugins being closed in the background.
+	stopping sync.WaitGroup
+}
+
+// NewLazyPlugin returns a LazyPlugin that starts its plugin using start, and
+// stops it once it has had no calls in progress for the given idle period.  If
+// idle is zero or less, the plugin keeps running once it has been started,
+// until the LazyPlugin is closed.
+func NewLazyPlugin(start StartFunc, idle time.Duration) *LazyPlugin {
+	return &LazyPlugin{start: start, idle: idle}
+}
+
+// Call invokes the named function on the plugin, starting the plugin first if
+// it isn't running, waits for it to complete, and returns its error status.
+func (l *LazyPlugin) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	call := <-l.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1)).Done
+	return call.Error
+}
+
+// Go invokes the named function on the plugin asynchronously, like
+// rpc.Client.Go, starting the plugin first if it isn't running.  If the plugin
+// can't be started, the call fails with the error from starting it.
+func (l *LazyPlugin) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	return goVia(func() (*Plugin, func(), error) {
+		p, err := l.acquire()
+		if err != nil {
+			return nil, nil, err
+		}
+		return p, func() { l.release(p) }, nil
+	}, serviceMethod, args, reply, done)
+}
+
+// Running reports whether the plugin application is running.
+func (l *LazyPlugin) Running() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.plugin == nil {
+		return false
+	}
+	select {
+	case <-l.plugin.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+// Close shuts down the plugin application, if it is running.  Calls made after
+// Close fail with ErrClosed.
+func (l *LazyPlugin) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	p := l.plugin
+	l.plugin = nil
+	starting := l.starting
+	l.mu.Unlock()
+	var err error
+	if p != nil {
+		err = p.Close()
+	}
+	if starting != nil {
+		// A plugin that finishes starting now is closed by the call that
+		// started it.
+		<-starting
+	}
+	l.stopping.Wait()
+	return err
+}
+
+// acquire returns the running plugin, starting it if necessary, having counted
+// the call about to be sent to it.  The plugin is started without holding l.mu,
+// so that a slow start doesn't hold up the LazyPlugin's other methods.
+func (l *LazyPlugin) acquire() (*Plugin, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		if l.closed {
+			return nil, ErrClosed
+		}
+		if l.plugin != nil {
+			select {
+			case <-l.plugin.Done():
+				// The plugin exited on its own.  Close it in the
+				// background and start another.
+				l.stopping.Add(1)
+				go func(p *Plugin) {
+					defer l.stopping.Done()
+					p.Close()
+				}(l.plugin)
+				l.plugin = nil
+			default:
+			}
+		}
+		if l.plugin != nil {
+			break
+		}
+		if starting := l.starting; starting != nil {
+			// Calls made while the plugin is starting wait for it here.
+			l.mu.Unlock()
+			<-starting
+			l.mu.Lock()
+			continue
+		}
+		if err := l.startLocked(); err != nil {
+			return nil, err
+		}
+	}
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.calls++
+	l.gen++
+	return l.plugin, nil
+}
+
+// startLocked starts the plugin, unlocking l.mu while it does.  If the
+// LazyPlugin is closed in the meantime, the new plugin is closed again.
+func (l *LazyPlugin) startLocked() error {
+	starting := make(chan struct{})
+	l.starting = starting
+	l.mu.Unlock()
+	p, err := l.start()
+	l.mu.Lock()
+	l.starting = nil
+	if err == nil && l.closed {
+		// Close waits for starting, so it sees this before it waits for
+		// the plugins being stopped.
+		l.stopping.Add(1)
+		go func() {
+			defer l.stopping.Done()
+			p.Close()
+		}()
+	} else if err == nil {
+		l.plugin = p
+	}
+	close(starting)
+	return err
+}
+
+// release records that a call sent to p has completed, and starts the idle
+// timer once no calls are left.
+func (l *LazyPlugin) release(p *Plugin) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls--
+	l.gen++
+	if l.calls > 0 || l.idle <= 0 || l.plugin != p || l.closed {
+		return
+	}
+	gen := l.gen
+	l.timer = time.AfterFunc(l.idle, func() { l.stopIdle(gen) })
+}
+
+// stopIdle stops the plugin if nothing has happened since the idle timer for
+// gen was started.
+func (l *LazyPlugin) stopIdle(gen int) {
+	l.mu.Lock()
+	p := l.plugin
+	if l.gen != gen || l.closed || p == nil {
+		l.mu.Unlock()
+		return
+	}
+	l.plugin = nil
+	l.timer = nil
+	l.stopping.Add(1)
+	l.mu.Unlock()
+	defer l.stopping.Done()
+	p.Close()
+}
@@ -0,0 +1,140 @@
+package pie
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLazyPlugin(t *testing.T) {
+	var started []*Plugin
+	l := NewLazyPlugin(func() (*Plugin, error) {
+		p, err := startHelper(t, "provider")
+		if err == nil {
+			started = append(started, p)
+		}
+		return p, err
+	}, 100*time.Millisecond)
+	defer l.Close()
+
+	if l.Running() || len(started) != 0 {
+		t.Fatal("Expected plugin not to be started before it is called")
+	}
+	var pid1, pid2 int
+	if err := l.Call("helper.PID", 0, &pid1); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if !l.Running() {
+		t.Fatal("Expected plugin to be running after it was called")
+	}
+	if !eventually(func() bool { return !l.Running() }) {
+		t.Fatal("Expected idle plugin to be stopped")
+	}
+	<-started[0].Done()
+	if err := started[0].Err(); err != ErrClosed {
+		t.Fatalf("Expected idle plugin to be closed, got %#v", err)
+	}
+
+	if err := l.Call("helper.PID", 0, &pid2); err != nil {
+		t.Fatalf("Unexpected error from Call after idle stop: %#v", err)
+	}
+	if len(started) != 2 || pid1 == pid2 {
+		t.Fatalf("Expected plugin to be started again, got %d starts and pids %d, %d", len(started), pid1, pid2)
+	}
+}
+
+func TestLazyPluginBusy(t *testing.T) {
+	l := NewLazyPlugin(func() (*Plugin, error) {
+		return startHelper(t, "provider")
+	}, 50*time.Millisecond)
+	defer l.Close()
+
+	// A call that takes longer than the idle period keeps the plugin running.
+	if err := l.Call("helper.Sleep", 200*time.Millisecond, nil); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if !l.Running() {
+		t.Fatal("Expected plugin to keep running while it was busy")
+	}
+}
+
+func TestLazyPluginClose(t *testing.T) {
+	l := NewLazyPlugin(func() (*Plugin, error) {
+		t.Fatal("Plugin started unexpectedly")
+		return nil, nil
+	}, time.Minute)
+	if err := l.Close(); err != nil {
+		t.Fatalf("Unexpected error closing plugin that never started: %#v", err)
+	}
+	if err := l.Call("helper.PID", 0, new(int)); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Call after Close, got %#v", err)
+	}
+}
+
+func TestLazyPluginSlowStart(t *testing.T) {
+	entered := make(chan struct{})
+	proceed := make(chan struct{})
+	l := NewLazyPlugin(func() (*Plugin, error) {
+		close(entered)
+		<-proceed
+		return startHelper(t, "provider")
+	}, time.Minute)
+	defer l.Close()
+
+	called := make(chan error, 1)
+	go func() { called <- l.Call("helper.PID", 0, new(int)) }()
+	<-entered
+
+	// The LazyPlugin can still be used while its plugin starts.
+	running := make(chan bool, 1)
+	go func() { running <- l.Running() }()
+	select {
+	case r := <-running:
+		if r {
+			t.Error("Expected plugin not to be running while it was starting")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Running blocked while the plugin was starting")
+	}
+
+	close(proceed)
+	if err := <-called; err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if !l.Running() {
+		t.Fatal("Expected plugin to be running after it was called")
+	}
+}
+
+func TestLazyPluginCloseWhileStarting(t *testing.T) {
+	entered := make(chan struct{})
+	proceed := make(chan struct{})
+	var started *Plugin
+	l := NewLazyPlugin(func() (*Plugin, error) {
+		close(entered)
+		<-proceed
+		p, err := startHelper(t, "provider")
+		started = p
+		return p, err
+	}, time.Minute)
+
+	called := make(chan error, 1)
+	go func() { called <- l.Call("helper.PID", 0, new(int)) }()
+	<-entered
+
+	closed := make(chan error, 1)
+	go func() { closed <- l.Close() }()
+	// Close waits for the plugin to finish starting so that it can stop it.
+	time.Sleep(50 * time.Millisecond)
+	close(proceed)
+	if err := <-closed; err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	if err := <-called; err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Call interrupted by Close, got %#v", err)
+	}
+	select {
+	case <-started.Done():
+	default:
+		t.Fatal("Expected plugin started during Close to be stopped before Close returned")
+	}
+}
@@ -0,0 +1,187 @@
+package pie
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// defaultDrain is how long in-flight calls are given to finish once the host
+// is gone, unless NewLifecycle is told otherwise.
+const defaultDrain = 10 * time.Second
+
+// parentPoll is how often a Lifecycle checks whether the host process has
+// exited.
+var parentPoll = time.Second
+
+// The reasons a Lifecycle stops the plugin, as returned by context.Cause.
+var (
+	errHostClosed  = errors.New("host closed the connection")
+	errHostStopped = errors.New("host asked the plugin to stop")
+	errHostExited  = errors.New("host process exited")
+)
+
+// Lifecycle ties a plugin application's lifetime to its host's, so that the
+// plugin doesn't keep running after the host is done with it or has died,
+// even while it is busy.  It stops the plugin when the host closes the
+// connection, when the host sends the plugin an interrupt or SIGTERM, as it
+// does when the Plugin is closed, and when the host process exits, which it
+// notices by the plugin's parent process changing.
+//
+// Stopping the plugin cancels the Lifecycle's Context, which the plugin should
+// hand to the services it registers, so that long calls can give up early.
+// Once the calls being served have returned, or the drain timeout has passed,
+// the plugin exits.  Work the plugin does outside of RPC calls should also
+// watch the Context, but isn't waited for.
+//
+// A plugin application should only have one Lifecycle, created before it
+// starts serving or consuming.
+type Lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	drain  time.Duration
+	once   sync.Once
+
+	mu sync.Mutex
+	// server is the Server being served, if any.
+	server *Server
+}
+
+// NewLifecycle starts watching for the host to go away.  Drain is how long the
+// calls being served are given to finish once it has; if it is zero or less,
+// they are given ten seconds.
+func NewLifecycle(drain time.Duration) *Lifecycle {
+	if drain <= 0 {
+		drain = defaultDrain
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	l := &Lifecycle{ctx: ctx, cancel: cancel, drain: drain}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, stopSignals...)
+	go func() {
+		<-sigs
+		l.stop(errHostStopped)
+	}()
+	go l.watchParent(os.Getppid())
+	return l
+}
+
+// Context returns a context that is canceled when the plugin is stopping.
+// context.Cause says why.
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Serve serves s like Server.Serve, and stops the plugin once the host hangs
+// up.  It never returns.
+func (l *Lifecycle) Serve(s Server) {
+	l.serve(s, "gob", newGobServerCodec)
+}
+
+// ServeCodec serves s like Server.ServeCodec, and stops the plugin once the
+// host hangs up.  It never returns.
+func (l *Lifecycle) ServeCodec(s Server, f func(io.ReadWriteCloser) rpc.ServerCodec) {
+	l.serve(s, "", f)
+}
+
+// serve serves s using the codec returned by f, and stops the plugin once
+// serving is done.
+func (l *Lifecycle) serve(s Server, codec string, f func(io.ReadWriteCloser) rpc.ServerCodec) {
+	s.rwc = hostConn{s.rwc, l}
+	if s.state == nil {
+		s.state = newServerState()
+	}
+	// The Lifecycle shuts the Server down itself.
+	s.state.signals = false
+	l.mu.Lock()
+	l.server = &s
+	l.mu.Unlock()
+	s.serve(codec, f)
+	l.stop(errHostClosed)
+	select {}
+}
+
+// NewConsumer is like the NewConsumer function, but the plugin is stopped once
+// the host hangs up.
+func (l *Lifecycle) NewConsumer() *rpc.Client {
+	rwc := hostConn{stdio(), l}
+	logHandshake(answerHandshake(rwc, "gob", nil))
+	return rpc.NewClient(rwc)
+}
+
+// NewConsumerCodec is like the NewConsumerCodec function, but the plugin is
+// stopped once the host hangs up.
+func (l *Lifecycle) NewConsumerCodec(f func(io.ReadWriteCloser) rpc.ClientCodec) *rpc.Client {
+	rwc := hostConn{stdio(), l}
+	logHandshake(answerHandshake(rwc, "", nil))
+	return rpc.NewClientWithCodec(f(rwc))
+}
+
+// watchParent stops the plugin once its parent is no longer ppid.
+func (l *Lifecycle) watchParent(ppid int) {
+	t := time.NewTicker(parentPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if os.Getppid() != ppid {
+				l.stop(errHostExited)
+				return
+			}
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// stop stops the plugin for the given reason, the first time it is called.
+func (l *Lifecycle) stop(cause error) {
+	l.once.Do(func() {
+		log.Printf("pie: stopping plugin: %s", cause)
+		l.cancel(cause)
+		go l.exit(cause)
+	})
+}
+
+// exit shuts down the Server being served, if any, giving the calls in flight
+// until the drain timeout to finish, and exits.  If the host asked the plugin
+// to stop, they are given no longer than the host waits.
+func (l *Lifecycle) exit(cause error) {
+	drain := l.drain
+	if cause == errHostStopped && hostGrace() < drain {
+		drain = hostGrace()
+	}
+	l.mu.Lock()
+	s := l.server
+	l.mu.Unlock()
+	if s != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), drain)
+		if err := s.Shutdown(ctx); err == context.DeadlineExceeded {
+			log.Printf("pie: calls still running after %s", drain)
+		}
+		cancel()
+	}
+	os.Exit(0)
+}
+
+// hostConn is the plugin's connection to its host, which stops the plugin
+// once it can't be read any more.
+type hostConn struct {
+	io.ReadWriteCloser
+	l *Lifecycle
+}
+
+func (c hostConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if err != nil {
+		c.l.stop(errHostClosed)
+	}
+	return n, err
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package pie
+
+import "os"
+
+// stopSignals are the signals that make a Lifecycle stop the plugin.
+var stopSignals = []os.Signal{os.Interrupt}
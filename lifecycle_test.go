@@ -0,0 +1,71 @@
+package pie
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lifecycleAPI is served by TestHelperProcess in lifecycle mode.
+type lifecycleAPI struct {
+	ctx context.Context
+}
+
+// Wait returns why the plugin is stopping, once it is.
+func (a lifecycleAPI) Wait(_ int, reason *string) error {
+	<-a.ctx.Done()
+	*reason = context.Cause(a.ctx).Error()
+	return nil
+}
+
+// serveLifecycle serves the helper API using a Lifecycle, telling the host on
+// stderr when it is ready.
+func serveLifecycle() {
+	l := NewLifecycle(0)
+	p := NewProvider()
+	p.RegisterName("helper", helper{})
+	p.RegisterName("lifecycle", lifecycleAPI{l.Context()})
+	fmt.Fprintln(os.Stderr, "ready")
+	l.Serve(p)
+}
+
+// lifecycleCmd returns a command that runs this test binary as a plugin that
+// uses a Lifecycle.
+func lifecycleCmd() *exec.Cmd {
+	cmd := exec.Command(os.Args[0], helperArgs("lifecycle")...)
+	cmd.Env = append(os.Environ(), helperEnv+"=1")
+	return cmd
+}
+
+func TestLifecycleHostClosed(t *testing.T) {
+	cmd := lifecycleCmd()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	in.Close()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected plugin to exit cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("Expected plugin to exit once the host closed the connection")
+	}
+	if !strings.Contains(stderr.String(), errHostClosed.Error()) {
+		t.Errorf("Expected plugin to log why it stopped, got %q", stderr.String())
+	}
+}
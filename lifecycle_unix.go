@@ -0,0 +1,11 @@
+//go:build unix
+
+package pie
+
+import (
+	"os"
+	"syscall"
+)
+
+// stopSignals are the signals that make a Lifecycle stop the plugin.
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
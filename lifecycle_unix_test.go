@@ -0,0 +1,113 @@
+//go:build unix
+
+package pie
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLifecycleInterrupt(t *testing.T) {
+	p, err := startHelper(t, "lifecycle")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	defer p.Close()
+
+	var reason string
+	call := p.Go("lifecycle.Wait", 0, &reason, nil)
+	// Make sure the call has arrived before interrupting the plugin.
+	var pid int
+	if err := p.Call("helper.PID", 0, &pid); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if err := syscall.Kill(pid, syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-call.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected call to return once the plugin was interrupted")
+	}
+	if call.Error != nil || reason != errHostStopped.Error() {
+		t.Errorf("Expected call to be told the host stopped the plugin, got %q, %v", reason, call.Error)
+	}
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected plugin to exit once its calls were done")
+	}
+	var exitErr *ExitError
+	if !errors.As(p.Err(), &exitErr) || !exitErr.State.Success() {
+		t.Errorf("Expected plugin to exit cleanly, got %v", p.Err())
+	}
+}
+
+func TestLifecycleHostExited(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to start the plugin with")
+	}
+	// The shell starts the plugin and exits when told to, leaving the
+	// plugin's stdin open, which the shell would otherwise replace with
+	// /dev/null.  The test's own pipes are used, since Wait closes those
+	// made by exec.Cmd.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	plugin := lifecycleCmd()
+	cmd := exec.Command("sh", append([]string{"-c", `exec 4<&0; "$0" "$@" <&4 4<&- & read x <&3`}, plugin.Args...)...)
+	cmd.Env = plugin.Env
+	cmd.ExtraFiles = []*os.File{r}
+	stdin, in, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	cmd.Stdin = stdin
+	// The plugin has the other end of the stderr pipe, so reading reaches
+	// the end once it has exited.
+	stderr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	cmd.Stderr = pw
+	err = cmd.Start()
+	r.Close()
+	stdin.Close()
+	pw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := bufio.NewReader(stderr)
+	if line, err := out.ReadString('\n'); err != nil || line != "ready\n" {
+		t.Fatalf("Expected plugin to be ready, got %q, %v", line, err)
+	}
+	w.Write([]byte("exit\n"))
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan string, 1)
+	go func() {
+		b, _ := ioutil.ReadAll(out)
+		done <- string(b)
+	}()
+	select {
+	case rest := <-done:
+		if !strings.Contains(rest, errHostExited.Error()) {
+			t.Errorf("Expected plugin to stop because the host exited, got %q", rest)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected plugin to exit once the host had exited")
+	}
+}
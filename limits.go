@@ -0,0 +1,68 @@
+package pie
+
+import "time"
+
+// Limits are resource limits for a plugin application's process, set using
+// setrlimit just before the plugin is executed.  Zero fields aren't limited.
+// Like any resource limits, they are inherited by the plugin's own child
+// processes.
+type Limits struct {
+	// AddressSpace is the most virtual memory, in bytes, the process may
+	// map.  Note that Go programs reserve much more address space than they
+	// use.
+	AddressSpace uint64
+	// CPUTime is the most CPU time the process may use, rounded up to the
+	// second.  It is killed once it has used that much.
+	CPUTime time.Duration
+	// OpenFiles is one more than the highest file descriptor the process may
+	// open.
+	OpenFiles uint64
+	// Processes is the most processes that may be running as the plugin's
+	// user.  On most systems, that counts every process the user is running,
+	// not just the plugin's.
+	Processes uint64
+}
+
+// WithLimits sets resource limits on the plugin application.  The limits are
+// set by a small shim: the host's own executable is started in place of the
+// plugin, and this package's init function sets the limits and executes the
+// plugin.  So the host must import this package, as it does to start plugins,
+// and must not rely on its executable being started only by the user.
+//
+// Go only guarantees that the packages this package imports are initialized
+// before it, so the init functions of the host's other packages may run in the
+// shim before the plugin is executed.  They shouldn't have effects outside the
+// process, such as writing files or connecting to servers.
+//
+// WithLimits is only supported on unix systems.
+func WithLimits(l Limits) Option {
+	return func(cfg *config) { cfg.limits = l }
+}
+
+// Cgroup describes a cgroup v2 to run the plugin application in, to limit
+// the memory and CPU used by the plugin and all its child processes together.
+type Cgroup struct {
+	// Parent is the cgroup directory under which a cgroup is created for the
+	// plugin, such as a directory under /sys/fs/cgroup delegated to the
+	// host's user.  The memory and cpu controllers must be enabled in its
+	// cgroup.subtree_control.
+	Parent string
+	// MemoryMax is the most memory, in bytes, the plugin may use, written to
+	// memory.max.  Zero means no limit.
+	MemoryMax uint64
+	// CPUMax is how many CPUs' worth of time the plugin may use, such as 0.5
+	// for half of one CPU, written to cpu.max.  Zero means no limit.
+	CPUMax float64
+}
+
+// WithCgroup runs the plugin application in a new cgroup created under
+// cg.Parent.  The plugin is started directly in the cgroup, so it can't use
+// more than it is allowed even briefly.  When the plugin exits, anything
+// left running in the cgroup is killed and the cgroup is removed.
+//
+// WithCgroup is only supported on Linux 5.7 and later.  Before Linux 5.14,
+// which can kill a whole cgroup at once, anything left running is killed one
+// process at a time.
+func WithCgroup(cg Cgroup) Option {
+	return func(cfg *config) { cfg.cgroup = &cg }
+}
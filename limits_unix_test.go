@@ -0,0 +1,64 @@
+//go:build unix
+
+package pie
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Rlimit returns the plugin application's soft limit for a resource.
+func (helper) Rlimit(resource int, limit *uint64) error {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &rlim); err != nil {
+		return err
+	}
+	*limit = uint64(rlim.Cur)
+	return nil
+}
+
+func TestWithLimits(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"),
+		WithLimits(Limits{OpenFiles: 100, CPUTime: 1500 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	defer p.Close()
+
+	for resource, expected := range map[int]uint64{
+		syscall.RLIMIT_NOFILE: 100,
+		syscall.RLIMIT_CPU:    2,
+	} {
+		var limit uint64
+		if err := p.Call("helper.Rlimit", resource, &limit); err != nil {
+			t.Fatalf("Unexpected error from Call: %#v", err)
+		}
+		if limit != expected {
+			t.Errorf("Expected limit %d on resource %d, got %d", expected, resource, limit)
+		}
+	}
+	var shim string
+	if err := p.Call("helper.Getenv", shimEnv, &shim); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if shim != "" {
+		t.Errorf("Expected %s to be cleared before starting the plugin, got %q", shimEnv, shim)
+	}
+}
+
+func TestWithLimitsNotGo(t *testing.T) {
+	p, err := StartProviderWith(os.Stderr, "sh", []string{"-c", "exit 0"},
+		WithLimits(Limits{OpenFiles: 100}))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	defer p.Close()
+	<-p.Done()
+	state, _ := p.Wait()
+	if state.ExitCode() != 0 {
+		t.Errorf("Expected plugin to run and exit 0, got %s: %s", state, p.Stderr())
+	}
+}
@@ -0,0 +1,313 @@
+package pie
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManagerConfig configures how a Manager starts its plugins.
+type ManagerConfig struct {
+	// Output receives the stderr of every plugin.
+	Output io.Writer
+	// Args are passed to every plugin that doesn't have a manifest.
+	Args []string
+	// Options are used to start every plugin, as with StartProviderWith.
+	Options []Option
+}
+
+// Manager starts the provider plugin applications found in one or more
+// directories, and keeps track of them by name.  A plugin's name is the name of
+// its executable, without the .exe extension on Windows, unless it has a
+// manifest file, in which case the manifest says how to start the plugin and
+// what it is called.
+type Manager struct {
+	cfg ManagerConfig
+
+	mu      sync.Mutex
+	plugins map[string]*managed
+	// starting holds where the plugins being started were found, by name.
+	starting map[string]string
+}
+
+// managed is a plugin started by a Manager.
+type managed struct {
+	path     string
+	manifest string
+	plugin   *Plugin
+}
+
+// PluginStatus describes a plugin started by a Manager.
+type PluginStatus struct {
+	Name string
+	// Path is the path of the plugin's executable.
+	Path string
+	// Manifest is the path of the manifest file describing the plugin, or
+	// empty if it doesn't have one.
+	Manifest string
+	PID      int
+	// Running is true until the plugin application exits.
+	Running bool
+	// Err is why the plugin exited, as returned by Plugin.Err.  It is nil
+	// while the plugin is running.
+	Err  error
+	Info PluginInfo
+}
+
+// NewManager returns a Manager that starts plugins according to cfg.
+func NewManager(cfg ManagerConfig) *Manager {
+	return &Manager{cfg: cfg, plugins: map[string]*managed{}, starting: map[string]string{}}
+}
+
+// pluginStart is a plugin that a Manager is going to start.
+type pluginStart struct {
+	name     string
+	path     string
+	manifest string
+	start    StartFunc
+}
+
+// Load starts the provider plugins in the given directories: those described
+// by manifest files (see ManifestExt), and every other executable file.  An
+// executable that is the command of a manifest is only started as the manifest
+// says.  Subdirectories and files whose names start with a dot are skipped.  A
+// plugin that fails to start, or whose name is already taken by another
+// plugin, doesn't stop the rest from being started; Load returns an error for
+// each plugin it couldn't start.  The plugins in a directory are started at the
+// same time, but if two of them have the same name, the one described by a
+// manifest wins, and otherwise the one whose file name sorts first.
+func (m *Manager) Load(dirs ...string) error {
+	var errs []error
+	for _, dir := range dirs {
+		if err := m.load(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// load starts the plugins in dir.
+func (m *Manager) load(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("can't read plugin directory: %w", err)
+	}
+	var errs []error
+	var paths []string
+	var starts []pluginStart
+	covered := map[string]bool{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), ManifestExt) {
+			paths = append(paths, path)
+			continue
+		}
+		mf, err := ReadManifest(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if mf.Role != RoleProvider {
+			errs = append(errs, fmt.Errorf("can't start plugin %s: manager only starts providers", mf.Name))
+			continue
+		}
+		covered[filepath.Clean(mf.Path())] = true
+		start := func() (*Plugin, error) {
+			return mf.StartProvider(m.cfg.Output, m.cfg.Options...)
+		}
+		starts = append(starts, pluginStart{mf.Name, mf.Path(), path, start})
+	}
+	for _, path := range paths {
+		if covered[filepath.Clean(path)] || !isExecutable(path) {
+			continue
+		}
+		path := path
+		start := func() (*Plugin, error) {
+			return StartProviderWith(m.cfg.Output, path, m.cfg.Args, m.cfg.Options...)
+		}
+		starts = append(starts, pluginStart{pluginName(path), path, "", start})
+	}
+	return errors.Join(append(errs, m.startAll(starts)...)...)
+}
+
+// startAll starts the given plugins at the same time, and waits for them all
+// to start.  It returns an error for each plugin that couldn't be started.
+func (m *Manager) startAll(starts []pluginStart) []error {
+	errs := make([]error, len(starts))
+	var wg sync.WaitGroup
+	for i, ps := range starts {
+		// Names are reserved in order, so that it's predictable which of
+		// two plugins with the same name is started.
+		if errs[i] = m.reserve(ps.name, foundAt(ps.path, ps.manifest)); errs[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, ps pluginStart) {
+			defer wg.Done()
+			errs[i] = m.start(ps)
+		}(i, ps)
+	}
+	wg.Wait()
+	return errs
+}
+
+// reserve claims name for the plugin found at path, unless another plugin has
+// already been started, or is being started, with that name.
+func (m *Manager) reserve(name, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	other, ok := m.starting[name]
+	if mp, started := m.plugins[name]; started {
+		other, ok = foundAt(mp.path, mp.manifest), true
+	}
+	if ok {
+		return fmt.Errorf("can't start plugin %s from %s: already started from %s", name, path, other)
+	}
+	m.starting[name] = path
+	return nil
+}
+
+// start starts a plugin whose name has been reserved, and tracks it by name.
+// Starting a plugin can take as long as its handshake, so the Manager isn't
+// locked meanwhile.
+func (m *Manager) start(ps pluginStart) error {
+	p, err := ps.start()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.starting, ps.name)
+	if err != nil {
+		return fmt.Errorf("can't start plugin %s: %w", ps.name, err)
+	}
+	m.plugins[ps.name] = &managed{path: ps.path, manifest: ps.manifest, plugin: p}
+	return nil
+}
+
+// Get returns the plugin with the given name, and whether it was found.  The
+// plugin may have since exited; check its Err or Done.
+func (m *Manager) Get(name string) (*Plugin, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mp, ok := m.plugins[name]
+	if !ok {
+		return nil, false
+	}
+	return mp.plugin, true
+}
+
+// Status returns the status of the plugin with the given name, and whether it
+// was found.
+func (m *Manager) Status(name string) (PluginStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mp, ok := m.plugins[name]
+	if !ok {
+		return PluginStatus{}, false
+	}
+	return mp.status(name), true
+}
+
+// List returns the status of every plugin, sorted by name.
+func (m *Manager) List() []PluginStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]PluginStatus, 0, len(m.plugins))
+	for name, mp := range m.plugins {
+		list = append(list, mp.status(name))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// StopAll closes every plugin at the same time, waits for them all to exit,
+// and forgets them, so that they may be loaded again.  It returns an error for
+// each plugin that couldn't be stopped cleanly.  Plugins that are still being
+// started by Load aren't stopped.
+func (m *Manager) StopAll() error {
+	m.mu.Lock()
+	plugins := m.plugins
+	m.plugins = map[string]*managed{}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	// Each plugin may fail to stop cleanly and then fail to exit.
+	errs := make(chan error, 2*len(plugins))
+	for name, mp := range plugins {
+		wg.Add(1)
+		go func(name string, p *Plugin) {
+			defer wg.Done()
+			if err := p.Close(); err != nil {
+				errs <- fmt.Errorf("error stopping plugin %s: %w", name, err)
+			}
+			// A killed plugin may not be gone quite yet, and one that
+			// couldn't be signalled may not be going at all.
+			select {
+			case <-p.Done():
+			case <-time.After(exitWait):
+				errs <- fmt.Errorf("plugin %s did not exit after being stopped", name)
+			}
+		}(name, mp.plugin)
+	}
+	wg.Wait()
+	close(errs)
+	var all []error
+	for err := range errs {
+		all = append(all, err)
+	}
+	return errors.Join(all...)
+}
+
+// status returns the status of the plugin, which is tracked under name.
+func (mp *managed) status(name string) PluginStatus {
+	err := mp.plugin.Err()
+	return PluginStatus{
+		Name:     name,
+		Path:     mp.path,
+		Manifest: mp.manifest,
+		PID:      mp.plugin.PID(),
+		Running:  err == nil,
+		Err:      err,
+		Info:     mp.plugin.Info(),
+	}
+}
+
+// foundAt returns where a plugin was found: its manifest, if it has one, or
+// else its executable.
+func foundAt(path, manifest string) string {
+	if manifest != "" {
+		return manifest
+	}
+	return path
+}
+
+// pluginName returns the name of the plugin whose executable is at path.
+func pluginName(path string) string {
+	name := filepath.Base(path)
+	if runtime.GOOS == "windows" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+// isExecutable reports whether path is a file that can be run as a plugin.
+// Symbolic links are followed.
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(path), ".exe")
+	}
+	return fi.Mode().Perm()&0111 != 0
+}
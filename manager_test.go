@@ -0,0 +1,222 @@
+package pie
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pluginDir returns a directory containing links to this test binary under
+// the given names, along with a few files that aren't plugins.
+func pluginDir(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.Symlink(os.Args[0], filepath.Join(dir, name)); err != nil {
+			t.Skipf("can't link to test binary: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newTestManager(t *testing.T) *Manager {
+	return NewManager(ManagerConfig{
+		Output:  os.Stderr,
+		Args:    helperArgs("provider"),
+		Options: []Option{WithEnv(helperEnv + "=1")},
+	})
+}
+
+func TestManager(t *testing.T) {
+	m := newTestManager(t)
+	defer m.StopAll()
+	if err := m.Load(pluginDir(t, "beta", "alpha")); err != nil {
+		t.Fatalf("Unexpected error from Load: %#v", err)
+	}
+
+	list := m.List()
+	if len(list) != 2 || list[0].Name != "alpha" || list[1].Name != "beta" {
+		t.Fatalf("Expected plugins alpha and beta, got %#v", list)
+	}
+	for _, status := range list {
+		if !status.Running || status.Err != nil || status.PID <= 0 {
+			t.Errorf("Expected %s to be running, got %#v", status.Name, status)
+		}
+	}
+
+	p, ok := m.Get("alpha")
+	if !ok {
+		t.Fatal("Expected to get plugin alpha")
+	}
+	var pid int
+	if err := p.Call("helper.PID", 0, &pid); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if pid != p.PID() {
+		t.Errorf("Expected alpha to be process %d, got %d", p.PID(), pid)
+	}
+	if _, ok := m.Get("README"); ok {
+		t.Error("Expected non-executable file not to be loaded")
+	}
+
+	p.Call("helper.Exit", 1, nil)
+	<-p.Done()
+	status, ok := m.Status("alpha")
+	if !ok {
+		t.Fatal("Expected status for plugin alpha")
+	}
+	if status.Running || !errors.Is(status.Err, ErrPluginExited) {
+		t.Errorf("Expected alpha to have exited, got %#v", status)
+	}
+}
+
+func TestManagerDuplicateName(t *testing.T) {
+	m := newTestManager(t)
+	defer m.StopAll()
+	dir1 := pluginDir(t, "alpha")
+	err := m.Load(dir1, pluginDir(t, "alpha", "beta"))
+	if err == nil {
+		t.Fatal("Expected error from Load for duplicate plugin name")
+	}
+	if status, _ := m.Status("alpha"); status.Path != filepath.Join(dir1, "alpha") {
+		t.Errorf("Expected the first alpha to be kept, got %#v", status)
+	}
+	if _, ok := m.Get("beta"); !ok {
+		t.Error("Expected beta to be started despite the error")
+	}
+}
+
+func TestManagerStopAll(t *testing.T) {
+	m := NewManager(ManagerConfig{
+		Output:  os.Stderr,
+		Args:    helperArgs("ignore-interrupt"),
+		Options: []Option{WithEnv(helperEnv + "=1"), WithStopTimeout(time.Second)},
+	})
+	if err := m.Load(pluginDir(t, "alpha", "beta", "gamma")); err != nil {
+		t.Fatalf("Unexpected error from Load: %#v", err)
+	}
+	var plugins []*Plugin
+	for _, status := range m.List() {
+		p, _ := m.Get(status.Name)
+		// Make sure the plugin is serving, and so ignoring interrupts.
+		if err := p.Call("helper.PID", 0, new(int)); err != nil {
+			t.Fatalf("Unexpected error from Call: %#v", err)
+		}
+		plugins = append(plugins, p)
+	}
+
+	start := time.Now()
+	err := m.StopAll()
+	if !errors.Is(err, ErrStopTimeout) {
+		t.Errorf("Expected ErrStopTimeout from StopAll, got %#v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Expected plugins to be stopped in parallel, took %s", d)
+	}
+	for _, p := range plugins {
+		select {
+		case <-p.Done():
+		default:
+			t.Errorf("Expected plugin %d to have exited", p.PID())
+		}
+	}
+	if list := m.List(); len(list) != 0 {
+		t.Errorf("Expected no plugins after StopAll, got %#v", list)
+	}
+}
+
+func TestManagerStopAllStuck(t *testing.T) {
+	defer func(d time.Duration) { exitWait = d }(exitWait)
+	exitWait = 10 * time.Millisecond
+	p := stuckProc{exited: make(chan struct{})}
+	defer close(p.exited)
+	pipe := newIOPipe(idleReader(), nopWCloser{ioutil.Discard}, p)
+	m := newTestManager(t)
+	m.plugins["stuck"] = &managed{path: "stuck", plugin: newPlugin(rpc.NewClient(pipe), pipe)}
+
+	done := make(chan error, 1)
+	go func() { done <- m.StopAll() }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "did not exit") {
+			t.Errorf("Expected error for plugin that did not exit, got %#v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopAll did not return for plugin that can't be stopped")
+	}
+}
+
+func TestManagerSlowStart(t *testing.T) {
+	m := newTestManager(t)
+	defer m.StopAll()
+	release := make(chan struct{})
+	slow := pluginStart{"slow", "slow", "", func() (*Plugin, error) {
+		<-release
+		return startHelper(t, "provider")
+	}}
+	if err := m.reserve(slow.name, slow.path); err != nil {
+		t.Fatalf("Unexpected error reserving name: %#v", err)
+	}
+	started := make(chan error, 1)
+	go func() { started <- m.start(slow) }()
+
+	// The Manager can be used while the plugin starts, and the plugin's
+	// name is taken meanwhile.
+	listed := make(chan []PluginStatus, 1)
+	go func() { listed <- m.List() }()
+	select {
+	case list := <-listed:
+		if len(list) != 0 {
+			t.Errorf("Expected plugin being started not to be listed, got %#v", list)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Manager locked while a plugin was starting")
+	}
+	if err := m.reserve("slow", "other"); err == nil {
+		t.Error("Expected error reserving name of plugin being started")
+	}
+
+	close(release)
+	if err := <-started; err != nil {
+		t.Fatalf("Unexpected error from start: %#v", err)
+	}
+	if _, ok := m.Get("slow"); !ok {
+		t.Error("Expected to get plugin once it started")
+	}
+}
+
+// stuckProc is a process that can't be signalled or killed, and so never
+// exits until the test is over.
+type stuckProc struct {
+	exited chan struct{}
+}
+
+func (p stuckProc) Wait() (*os.ProcessState, error) {
+	<-p.exited
+	return nil, nil
+}
+
+func (stuckProc) Kill() error {
+	return errors.New("can't kill")
+}
+
+func (stuckProc) Signal(os.Signal) error {
+	return errors.New("can't signal")
+}
+
+func TestManagerBadDir(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("Expected error from Load for missing directory")
+	}
+}
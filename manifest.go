@@ -0,0 +1,197 @@
+package pie
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ManifestExt is the extension of manifest files, which describe how to start
+// a plugin application.  A Manager loads the manifests it finds alongside the
+// plugin executables.
+const ManifestExt = ".plugin.json"
+
+// Manifest describes a plugin application, and how to start it, so that the
+// host doesn't need to hardcode the command or codec used by each plugin.  It
+// is usually read from a JSON file next to the plugin's executable:
+//
+//	{
+//		"name": "adder",
+//		"version": "1.0.0",
+//		"command": "python",
+//		"args": ["plugin.py"],
+//		"codec": "jsonrpc",
+//		"role": "provider",
+//		"services": ["add"]
+//	}
+type Manifest struct {
+	// Name is the name of the plugin.  It defaults to the name of the
+	// manifest file, without its extension.
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Command is the plugin's executable, and Args are the arguments it is
+	// started with.  A Command that is a relative path, or the name of a file
+	// in the manifest's directory, is found relative to the manifest's
+	// directory.  Otherwise it is looked for in $PATH.  The plugin is started
+	// in the manifest's directory.
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Codec is the name of the RPC codec the plugin speaks, "gob" or
+	// "jsonrpc", or any codec added using RegisterCodec.  It defaults to
+	// "gob".
+	Codec string `json:"codec,omitempty"`
+	// Role is "provider" if the plugin serves an API to the host, or
+	// "consumer" if it consumes one.  It defaults to "provider".
+	Role string `json:"role,omitempty"`
+	// Services are the names of the services the plugin provides.  They are
+	// for the host's information, and are not checked.
+	Services []string `json:"services,omitempty"`
+
+	// dir is the directory the manifest was read from.
+	dir string
+}
+
+// The roles a plugin may have in a Manifest.
+const (
+	RoleProvider = "provider"
+	RoleConsumer = "consumer"
+)
+
+// codec is the pair of functions used to talk a particular RPC codec.  Nil
+// functions mean gob.
+type codec struct {
+	client func(io.ReadWriteCloser) rpc.ClientCodec
+	server func(io.ReadWriteCloser) rpc.ServerCodec
+}
+
+var (
+	codecsMu sync.Mutex
+	codecs   = map[string]codec{
+		"gob":     {},
+		"jsonrpc": {jsonrpc.NewClientCodec, jsonrpc.NewServerCodec},
+	}
+)
+
+// RegisterCodec makes an RPC codec available to manifests under the given
+// name.  The client function is used to talk to provider plugins, and the
+// server function to serve consumer plugins.
+func RegisterCodec(
+	name string,
+	client func(io.ReadWriteCloser) rpc.ClientCodec,
+	server func(io.ReadWriteCloser) rpc.ServerCodec,
+) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = codec{client, server}
+}
+
+// lookupCodec returns the codec with the given name.
+func lookupCodec(name string) (codec, bool) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// ReadManifest reads the manifest file at path.  It returns an error if the
+// manifest is missing a command, or names an unknown codec or role.
+func ReadManifest(path string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid plugin manifest %s: %w", path, err)
+	}
+	if m.dir, err = filepath.Abs(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if m.Name == "" {
+		m.Name = strings.TrimSuffix(filepath.Base(path), ManifestExt)
+	}
+	if m.Codec == "" {
+		m.Codec = "gob"
+	}
+	if m.Role == "" {
+		m.Role = RoleProvider
+	}
+	switch {
+	case m.Command == "":
+		err = errors.New("no command")
+	case m.Role != RoleProvider && m.Role != RoleConsumer:
+		err = fmt.Errorf("unknown role %q", m.Role)
+	default:
+		if _, ok := lookupCodec(m.Codec); !ok {
+			err = fmt.Errorf("unknown codec %q", m.Codec)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Path returns the path of the plugin's executable, as started.
+func (m *Manifest) Path() string {
+	if filepath.IsAbs(m.Command) {
+		return m.Command
+	}
+	path := filepath.Join(m.dir, m.Command)
+	if strings.ContainsRune(m.Command, filepath.Separator) || strings.ContainsRune(m.Command, '/') {
+		return path
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return m.Command
+}
+
+// StartProvider starts the provider plugin described by m, with the given
+// options, writing its stderr to output.  Its RPC client uses the manifest's
+// codec.
+func (m *Manifest) StartProvider(output io.Writer, opts ...Option) (*Plugin, error) {
+	if m.Role == RoleConsumer {
+		return nil, fmt.Errorf("plugin %s is a %s, not a provider", m.Name, m.Role)
+	}
+	return StartProviderWith(output, m.Path(), m.Args, m.options(opts)...)
+}
+
+// StartConsumer starts the consumer plugin described by m, with the given
+// options, writing its stderr to output.  The returned Server should be
+// served using m.Serve, so that it uses the manifest's codec.
+func (m *Manifest) StartConsumer(output io.Writer, opts ...Option) (Server, error) {
+	if m.Role != RoleConsumer {
+		return Server{}, fmt.Errorf("plugin %s is a %s, not a consumer", m.Name, m.Role)
+	}
+	return StartConsumerWith(output, m.Path(), m.Args, m.options(opts)...)
+}
+
+// Serve serves s using the manifest's codec.  It blocks until the plugin hangs
+// up.
+func (m *Manifest) Serve(s Server) {
+	c, _ := lookupCodec(m.Codec)
+	if c.server == nil {
+		s.Serve()
+		return
+	}
+	s.ServeCodec(c.server)
+}
+
+// options returns the options for starting the plugin, followed by opts, which
+// may override them.
+func (m *Manifest) options(opts []Option) []Option {
+	base := []Option{WithDir(m.dir)}
+	if c, _ := lookupCodec(m.Codec); c.client != nil {
+		base = append(base, WithCodec(c.client))
+	}
+	return append(base, opts...)
+}
@@ -0,0 +1,127 @@
+package pie
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeManifest writes m as a manifest file named name in dir, and returns its
+// path.
+func writeManifest(t *testing.T, dir, name string, m interface{}) string {
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+ManifestExt)
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	m, err := ReadManifest(writeManifest(t, dir, "adder", map[string]interface{}{
+		"command":  "adder.py",
+		"services": []string{"add"},
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadManifest: %#v", err)
+	}
+	if m.Name != "adder" || m.Codec != "gob" || m.Role != RoleProvider {
+		t.Errorf("Expected defaults for name, codec and role, got %#v", m)
+	}
+	if len(m.Services) != 1 || m.Services[0] != "add" {
+		t.Errorf("Expected services [add], got %v", m.Services)
+	}
+}
+
+func TestReadManifestInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, m := range map[string]map[string]interface{}{
+		"no-command": {"name": "foo"},
+		"codec":      {"command": "foo", "codec": "carrier-pigeon"},
+		"role":       {"command": "foo", "role": "bystander"},
+		"json":       {"command": []string{"foo"}},
+	} {
+		if _, err := ReadManifest(writeManifest(t, dir, name, m)); err == nil {
+			t.Errorf("%s: expected error from ReadManifest", name)
+		}
+	}
+}
+
+func TestManifestPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "local"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for command, expected := range map[string]string{
+		"local":                         filepath.Join(dir, "local"),
+		"./bin/plugin":                  filepath.Join(dir, "bin", "plugin"),
+		"python":                        "python",
+		filepath.Join(dir, "elsewhere"): filepath.Join(dir, "elsewhere"),
+	} {
+		m := Manifest{Command: command, dir: dir}
+		if path := m.Path(); path != expected {
+			t.Errorf("Expected command %q to be found at %q, got %q", command, expected, path)
+		}
+	}
+}
+
+func TestManifestStartProvider(t *testing.T) {
+	dir := t.TempDir()
+	m, err := ReadManifest(writeManifest(t, dir, "helper", map[string]interface{}{
+		"command": os.Args[0],
+		"args":    helperArgs("jsonrpc"),
+		"codec":   "jsonrpc",
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadManifest: %#v", err)
+	}
+	p, err := m.StartProvider(os.Stderr, WithEnv(helperEnv+"=1"))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	defer p.Close()
+
+	var wd string
+	if err := p.Call("helper.Getwd", 0, &wd); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if !sameFile(t, wd, dir) {
+		t.Errorf("Expected plugin to run in the manifest's directory %q, got %q", dir, wd)
+	}
+	if _, err := m.StartConsumer(os.Stderr); err == nil || !strings.Contains(err.Error(), "not a consumer") {
+		t.Errorf("Expected error starting a provider as a consumer, got %#v", err)
+	}
+}
+
+func TestManagerManifest(t *testing.T) {
+	dir := pluginDir(t, "alpha")
+	writeManifest(t, dir, "adder", map[string]interface{}{
+		"command": "alpha",
+		"args":    helperArgs("jsonrpc"),
+		"codec":   "jsonrpc",
+	})
+	m := newTestManager(t)
+	defer m.StopAll()
+	if err := m.Load(dir); err != nil {
+		t.Fatalf("Unexpected error from Load: %#v", err)
+	}
+	list := m.List()
+	if len(list) != 1 || list[0].Name != "adder" {
+		t.Fatalf("Expected only the plugin described by the manifest, got %#v", list)
+	}
+	if list[0].Path != filepath.Join(dir, "alpha") || list[0].Manifest != filepath.Join(dir, "adder"+ManifestExt) {
+		t.Errorf("Expected status to give the executable and manifest paths, got %#v", list[0])
+	}
+	p, _ := m.Get("adder")
+	var pid int
+	if err := p.Call("helper.PID", 0, &pid); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+}
@@ -0,0 +1,567 @@
+package pie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// The multiplexing protocol sends frames with a 12 byte header: the protocol
+// version, the frame type, flags as a big-endian uint16, the stream id as a
+// big-endian uint32, and a big-endian uint32 length.  Data frames are followed
+// by length bytes of data for the stream.  Window frames carry no data; their
+// length is how many more bytes the receiver is prepared to buffer for the
+// stream.  Either kind of frame may carry flags that open, acknowledge,
+// half-close or reset the stream.
+const (
+	muxVersion = 0
+	muxHeader  = 12
+
+	frameData   = 0
+	frameWindow = 1
+
+	flagSYN = 1 << 0
+	flagACK = 1 << 1
+	flagFIN = 1 << 2
+	flagRST = 1 << 3
+	// flagRef marks a stream opened by OpenRef, which is claimed by Attach
+	// rather than Accept.
+	flagRef = 1 << 4
+)
+
+// The streams that multiplexed plugins use for RPC, which exist from the
+// start rather than being opened, named for who makes the calls on them.
+// Provider plugins only use hostCalls.
+// Other streams opened by the host have odd ids, and those opened by the
+// plugin have even ids.
+const (
+	hostCalls   = 1
+	pluginCalls = 2
+)
+
+const (
+	// maxFrame is the most data sent in a single frame, so that one stream
+	// can't hold up the others for long.
+	maxFrame = 32 * 1024
+	// streamWindow is how much data may be sent on a stream before the
+	// receiver has read it.
+	streamWindow = 256 * 1024
+	// acceptBacklog is how many streams the other side may open before they
+	// are accepted.  Streams opened beyond that are reset.
+	acceptBacklog = 64
+)
+
+// ErrStreamReset is returned by a Stream's Read and Write methods once the
+// stream has been reset by either side.
+var ErrStreamReset = errors.New("stream reset")
+
+// errSessionClosed is returned by a Session, and its streams, once it has been
+// closed.
+var errSessionClosed = errors.New("session closed")
+
+// Session multiplexes independent streams, such as RPC connections, log
+// channels and bulk data transfers, over the single connection between a host
+// and a bidirectional plugin application, or a provider plugin application
+// started using WithStreams, without needing extra pipes or file descriptors.
+// Each stream is flow controlled separately, so a stream whose data isn't being
+// read doesn't hold up the others.
+//
+// Either side may open streams, which the other side accepts, or open streams
+// to pass by StreamRef in RPC calls, which the other side attaches to.
+type Session struct {
+	conn io.ReadWriteCloser
+	wmu  sync.Mutex
+	// rpc are the RPC streams, hostCalls and pluginCalls.
+	rpc [2]*Stream
+
+	accept chan *Stream
+	// done is closed once the session has ended, after which err says why.
+	done chan struct{}
+	err  error
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	// pending are the streams opened by the other side's OpenRef that
+	// haven't been attached yet.
+	pending map[uint32]*Stream
+	nextID  uint32
+	ended   bool
+
+	// control are the frames acknowledging or resetting streams opened by
+	// the other side, which the read loop leaves to writeControl to send so
+	// that it never waits for the connection to be writable.  Otherwise two
+	// sessions could each be stuck writing to the other while neither is
+	// reading.  queued is signalled when frames are added.
+	cmu     sync.Mutex
+	control []controlFrame
+	queued  chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// controlFrame is a window frame, carrying no data or window update, that is
+// queued to be sent.
+type controlFrame struct {
+	flags uint16
+	id    uint32
+}
+
+// newSession starts multiplexing streams over conn, on the host's side of the
+// connection if host is true, or else on the plugin's.
+func newSession(conn io.ReadWriteCloser, host bool) *Session {
+	s := &Session{
+		conn:    conn,
+		accept:  make(chan *Stream, acceptBacklog),
+		done:    make(chan struct{}),
+		streams: map[uint32]*Stream{},
+		pending: map[uint32]*Stream{},
+		nextID:  pluginCalls + 2,
+		queued:  make(chan struct{}, 1),
+	}
+	if host {
+		s.nextID = hostCalls + 2
+	}
+	for i := range s.rpc {
+		st := newStream(s, uint32(i+1))
+		s.rpc[i] = st
+		s.streams[st.id] = st
+	}
+	go s.read()
+	go s.writeControl()
+	return s
+}
+
+// Open opens a new stream, which the other side receives from Accept.
+func (s *Session) Open() (*Stream, error) {
+	return s.open(flagSYN)
+}
+
+// open opens a new stream, telling the other side about it with a frame
+// carrying the given flags.
+func (s *Session) open(flags uint16) (*Stream, error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	st := newStream(s, s.nextID)
+	s.streams[st.id] = st
+	s.nextID += 2
+	s.mu.Unlock()
+	if err := s.writeFrame(frameWindow, flags, st.id, nil); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept waits for the other side to open a stream, and returns it.
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		return nil, s.err
+	}
+}
+
+// Close closes the session and the connection it uses, and with them every
+// stream.
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		s.end(errSessionClosed)
+		s.closeErr = s.conn.Close()
+	})
+	return s.closeErr
+}
+
+// rpcConn returns the RPC stream with the given id, as a connection whose
+// Close closes the whole session, since RPC clients and servers expect closing
+// their end to hang up.
+func (s *Session) rpcConn(id uint32) io.ReadWriteCloser {
+	return sessionConn{s.rpc[id-1]}
+}
+
+// sessionConn is a stream that closes its whole session when it is closed.
+type sessionConn struct {
+	*Stream
+}
+
+func (c sessionConn) Close() error {
+	return c.s.Close()
+}
+
+// read reads frames from the connection and hands them to the streams they are
+// for, until the connection fails or a frame can't be understood.
+func (s *Session) read() {
+	hdr := make([]byte, muxHeader)
+	for {
+		if _, err := io.ReadFull(s.conn, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			s.end(err)
+			return
+		}
+		typ := hdr[1]
+		flags := binary.BigEndian.Uint16(hdr[2:])
+		id := binary.BigEndian.Uint32(hdr[4:])
+		length := binary.BigEndian.Uint32(hdr[8:])
+		if hdr[0] != muxVersion || typ > frameWindow || typ == frameData && length > maxFrame {
+			s.end(fmt.Errorf("malformed frame: % x", hdr))
+			s.conn.Close()
+			return
+		}
+		var data []byte
+		if typ == frameData {
+			data = make([]byte, length)
+			if _, err := io.ReadFull(s.conn, data); err != nil {
+				s.end(io.EOF)
+				return
+			}
+		}
+		st := s.stream(id, flags)
+		if st == nil {
+			continue
+		}
+		var err error
+		if typ == frameWindow {
+			err = st.update(length, flags)
+		} else {
+			err = st.receive(data, flags)
+		}
+		if err != nil {
+			s.end(err)
+			s.conn.Close()
+			return
+		}
+	}
+}
+
+// stream returns the stream a frame with the given flags is for, opening it
+// if the frame asks to, or nil if the frame should be ignored.
+func (s *Session) stream(id uint32, flags uint16) *Stream {
+	s.mu.Lock()
+	st := s.streams[id]
+	if st != nil || flags&flagSYN == 0 || s.ended || id%2 == s.nextID%2 {
+		// Frames for streams that are already gone are ignored.
+		s.mu.Unlock()
+		return st
+	}
+	st = newStream(s, id)
+	if flags&flagRef != 0 {
+		if len(s.pending) >= acceptBacklog {
+			s.mu.Unlock()
+			s.queueControl(flagRST, id)
+			return nil
+		}
+		s.pending[id] = st
+		s.streams[id] = st
+		s.mu.Unlock()
+		s.queueControl(flagACK, id)
+		return st
+	}
+	select {
+	case s.accept <- st:
+		s.streams[id] = st
+		s.mu.Unlock()
+		s.queueControl(flagACK, id)
+		return st
+	default:
+		s.mu.Unlock()
+		s.queueControl(flagRST, id)
+		return nil
+	}
+}
+
+// queueControl queues a frame with the given flags for the stream with the
+// given id, to be sent by writeControl.
+func (s *Session) queueControl(flags uint16, id uint32) {
+	s.cmu.Lock()
+	s.control = append(s.control, controlFrame{flags, id})
+	s.cmu.Unlock()
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// writeControl sends the frames queued by queueControl, until the session
+// ends.
+func (s *Session) writeControl() {
+	for {
+		select {
+		case <-s.queued:
+		case <-s.done:
+			return
+		}
+		s.cmu.Lock()
+		control := s.control
+		s.control = nil
+		s.cmu.Unlock()
+		for _, f := range control {
+			if s.writeFrame(frameWindow, f.flags, f.id, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// remove forgets the stream with the given id, once it is closed in both
+// directions or has been reset.
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+	delete(s.pending, id)
+}
+
+// end ends the session for the given reason, failing every stream.
+func (s *Session) end(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.err = err
+	streams := s.streams
+	s.streams = map[uint32]*Stream{}
+	s.pending = map[uint32]*Stream{}
+	s.mu.Unlock()
+	close(s.done)
+	for _, st := range streams {
+		st.fail(err)
+	}
+}
+
+// writeFrame sends a frame carrying data, or no data, on the connection.
+func (s *Session) writeFrame(typ byte, flags uint16, id uint32, data []byte) error {
+	return s.write(typ, flags, id, uint32(len(data)), data)
+}
+
+// write sends a frame with the given header fields, followed by data.  Window
+// frames give the window update as their length.
+func (s *Session) write(typ byte, flags uint16, id uint32, length uint32, data []byte) error {
+	hdr := make([]byte, muxHeader, muxHeader+len(data))
+	hdr[0] = muxVersion
+	hdr[1] = typ
+	binary.BigEndian.PutUint16(hdr[2:], flags)
+	binary.BigEndian.PutUint32(hdr[4:], id)
+	binary.BigEndian.PutUint32(hdr[8:], length)
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	_, err := s.conn.Write(append(hdr, data...))
+	return err
+}
+
+// Stream is a connection multiplexed over a Session.  Closing a Stream only
+// closes it for writing; the other side reads io.EOF once it has read
+// everything written before.
+type Stream struct {
+	s   *Session
+	id  uint32
+	wmu sync.Mutex
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	// recvWindow is how much more data the other side may send, and unacked
+	// is how much has been read since the last window update.
+	recvWindow uint32
+	unacked    uint32
+	sendWindow uint32
+	// sentFIN and gotFIN are set once the stream is closed for writing and
+	// reading.
+	sentFIN bool
+	gotFIN  bool
+	// err is why the stream failed, if it has.
+	err error
+}
+
+// newStream returns the stream with the given id.
+func newStream(s *Session, id uint32) *Stream {
+	st := &Stream{s: s, id: id, recvWindow: streamWindow, sendWindow: streamWindow}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// ID returns the stream's id, which is unique within its Session.
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read reads data sent on the stream by the other side.  It returns io.EOF
+// once the other side has closed the stream and everything it sent has been
+// read.
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	for st.buf.Len() == 0 && !st.gotFIN && st.err == nil {
+		st.cond.Wait()
+	}
+	if st.buf.Len() == 0 {
+		defer st.mu.Unlock()
+		if st.err != nil {
+			return 0, st.err
+		}
+		return 0, io.EOF
+	}
+	n, _ := st.buf.Read(p)
+	st.unacked += uint32(n)
+	var update uint32
+	if st.unacked >= streamWindow/2 && !st.gotFIN {
+		update = st.unacked
+		st.recvWindow += update
+		st.unacked = 0
+	}
+	st.mu.Unlock()
+	if update > 0 {
+		st.s.write(frameWindow, 0, st.id, update, nil)
+	}
+	return n, nil
+}
+
+// Write sends p to the other side, waiting while the other side has as much
+// data buffered as it is prepared to.
+func (st *Stream) Write(p []byte) (int, error) {
+	st.wmu.Lock()
+	defer st.wmu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		st.mu.Lock()
+		for st.sendWindow == 0 && st.err == nil && !st.sentFIN {
+			st.cond.Wait()
+		}
+		switch {
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return written, err
+		case st.sentFIN:
+			st.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		n := uint32(len(p))
+		if n > st.sendWindow {
+			n = st.sendWindow
+		}
+		if n > maxFrame {
+			n = maxFrame
+		}
+		st.sendWindow -= n
+		st.mu.Unlock()
+		if err := st.s.writeFrame(frameData, 0, st.id, p[:n]); err != nil {
+			return written, err
+		}
+		written += int(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes the stream for writing.  The stream goes away once the other
+// side has closed it too.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.sentFIN || st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.sentFIN = true
+	done := st.gotFIN
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	if done {
+		st.s.remove(st.id)
+	}
+	return st.s.writeFrame(frameWindow, flagFIN, st.id, nil)
+}
+
+// Reset abandons the stream in both directions.  Reads and writes on both
+// sides of the stream fail with ErrStreamReset.
+func (st *Stream) Reset() error {
+	if !st.fail(ErrStreamReset) {
+		return nil
+	}
+	st.s.remove(st.id)
+	return st.s.writeFrame(frameWindow, flagRST, st.id, nil)
+}
+
+// receive buffers data sent by the other side, and handles the frame's flags.
+// It returns an error if the other side sent more than the stream's window.
+func (st *Stream) receive(data []byte, flags uint16) error {
+	st.mu.Lock()
+	if uint32(len(data)) > st.recvWindow {
+		st.mu.Unlock()
+		return fmt.Errorf("stream %d exceeded its window", st.id)
+	}
+	st.recvWindow -= uint32(len(data))
+	if st.err == nil && !st.gotFIN {
+		st.buf.Write(data)
+	}
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	st.flags(flags)
+	return nil
+}
+
+// update adds to how much may be sent on the stream, and handles the frame's
+// flags.  It returns an error if the other side made the window larger than
+// it can be.
+func (st *Stream) update(delta uint32, flags uint16) error {
+	st.mu.Lock()
+	if delta > streamWindow-st.sendWindow {
+		st.mu.Unlock()
+		return fmt.Errorf("stream %d window grew beyond %d bytes", st.id, streamWindow)
+	}
+	st.sendWindow += delta
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	st.flags(flags)
+	return nil
+}
+
+// flags handles the other side closing or resetting the stream.
+func (st *Stream) flags(flags uint16) {
+	switch {
+	case flags&flagRST != 0:
+		if st.fail(ErrStreamReset) {
+			st.s.remove(st.id)
+		}
+	case flags&flagFIN != 0:
+		st.mu.Lock()
+		st.gotFIN = true
+		done := st.sentFIN
+		st.cond.Broadcast()
+		st.mu.Unlock()
+		if done {
+			st.s.remove(st.id)
+		}
+	}
+}
+
+// fail makes the stream fail with err, once the data already received has
+// been read, or straight away if the stream was reset, unless it has already
+// failed.  It reports whether it had not.
+func (st *Stream) fail(err error) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err != nil {
+		return false
+	}
+	st.err = err
+	if err == ErrStreamReset {
+		// Data that hasn't been read yet is abandoned along with the stream.
+		st.buf.Reset()
+	}
+	st.cond.Broadcast()
+	return true
+}
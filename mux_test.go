@@ -0,0 +1,233 @@
+package pie
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionPair returns the host's and the plugin's side of a Session over an
+// in-memory connection.
+func sessionPair(t *testing.T) (host, plugin *Session) {
+	a, b := net.Pipe()
+	host, plugin = newSession(a, true), newSession(b, false)
+	t.Cleanup(func() {
+		host.Close()
+		plugin.Close()
+	})
+	return host, plugin
+}
+
+func TestSessionOpenAccept(t *testing.T) {
+	host, plugin := sessionPair(t)
+
+	st, err := host.Open()
+	if err != nil {
+		t.Fatalf("Unexpected error from Open: %#v", err)
+	}
+	if st.ID()%2 != 1 {
+		t.Errorf("Expected host to open stream with odd id, got %d", st.ID())
+	}
+	go func() {
+		st.Write([]byte("hello"))
+		st.Close()
+	}()
+	accepted, err := plugin.Accept()
+	if err != nil {
+		t.Fatalf("Unexpected error from Accept: %#v", err)
+	}
+	if accepted.ID() != st.ID() {
+		t.Errorf("Expected stream %d to be accepted, got %d", st.ID(), accepted.ID())
+	}
+	b, err := ioutil.ReadAll(accepted)
+	if err != nil || string(b) != "hello" {
+		t.Errorf("Expected %q from stream until EOF, got %q, %#v", "hello", b, err)
+	}
+
+	// Streams opened by the plugin have even ids.
+	st, err = plugin.Open()
+	if err != nil {
+		t.Fatalf("Unexpected error from Open: %#v", err)
+	}
+	if st.ID()%2 != 0 {
+		t.Errorf("Expected plugin to open stream with even id, got %d", st.ID())
+	}
+}
+
+func TestSessionFlowControl(t *testing.T) {
+	host, plugin := sessionPair(t)
+	slow, err := host.Open()
+	if err != nil {
+		t.Fatalf("Unexpected error from Open: %#v", err)
+	}
+	fast, err := host.Open()
+	if err != nil {
+		t.Fatalf("Unexpected error from Open: %#v", err)
+	}
+	slowIn, _ := plugin.Accept()
+	fastIn, _ := plugin.Accept()
+
+	// Writing more than the window to a stream nobody reads blocks...
+	data := bytes.Repeat([]byte("x"), 2*streamWindow)
+	wrote := make(chan int, 1)
+	go func() {
+		n, _ := slow.Write(data)
+		wrote <- n
+	}()
+	select {
+	case n := <-wrote:
+		t.Fatalf("Expected write beyond the window to block, wrote %d bytes", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// ...but doesn't hold up other streams.
+	go fast.Write([]byte("fast"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(fastIn, b); err != nil || string(b) != "fast" {
+		t.Fatalf("Expected %q from other stream, got %q, %#v", "fast", b, err)
+	}
+
+	// Once the data is read, the writer carries on.
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(slowIn, got); err != nil {
+		t.Fatalf("Unexpected error reading stream: %#v", err)
+	}
+	if n := <-wrote; n != len(data) || !bytes.Equal(got, data) {
+		t.Fatalf("Expected %d bytes to be sent intact, wrote %d", len(data), n)
+	}
+}
+
+func TestSessionBothSidesWrite(t *testing.T) {
+	host, plugin := sessionPair(t)
+	data := bytes.Repeat([]byte("x"), 2*streamWindow)
+
+	// Each side opens streams and fills them beyond their windows while the
+	// other side is doing the same, so the frames acknowledging the new
+	// streams cross paths with the data in both directions.
+	const streams = 4
+	errs := make(chan error, 4*streams)
+	for _, s := range []*Session{host, plugin} {
+		s := s
+		for i := 0; i < streams; i++ {
+			go func() {
+				st, err := s.Open()
+				if err == nil {
+					_, err = st.Write(data)
+					st.Close()
+				}
+				errs <- err
+			}()
+			go func() {
+				st, err := s.Accept()
+				if err == nil {
+					var b []byte
+					if b, err = ioutil.ReadAll(st); err == nil && !bytes.Equal(b, data) {
+						err = fmt.Errorf("read %d bytes, expected %d", len(b), len(data))
+					}
+				}
+				errs <- err
+			}()
+		}
+	}
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < cap(errs); i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("Unexpected error sending data both ways: %#v", err)
+			}
+		case <-timeout:
+			t.Fatal("Sessions deadlocked sending data both ways")
+		}
+	}
+}
+
+func TestSessionWindowOverflow(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	s := newSession(a, true)
+	defer s.Close()
+
+	// A window update that would take the stream's window beyond its size,
+	// or wrap it around, ends the session.
+	hdr := []byte{muxVersion, frameWindow, 0, 0, 0, 0, 0, hostCalls, 0xff, 0xff, 0xff, 0xff}
+	if _, err := b.Write(hdr); err != nil {
+		t.Fatalf("Unexpected error writing frame: %#v", err)
+	}
+	_, err := s.Accept()
+	if err == nil || !strings.Contains(err.Error(), "window") {
+		t.Fatalf("Expected window error ending the session, got %#v", err)
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	host, plugin := sessionPair(t)
+	st, _ := host.Open()
+	go st.Write([]byte("x"))
+	accepted, _ := plugin.Accept()
+	accepted.Reset()
+
+	if _, err := accepted.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Errorf("Expected ErrStreamReset reading reset stream, got %#v", err)
+	}
+	if !eventually(func() bool {
+		_, err := st.Write([]byte("x"))
+		return err == ErrStreamReset
+	}) {
+		t.Error("Expected ErrStreamReset writing to stream reset by the other side")
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	host, plugin := sessionPair(t)
+	st, _ := plugin.Open()
+
+	host.Close()
+	if _, err := plugin.Accept(); err != io.EOF {
+		t.Errorf("Expected io.EOF from Accept once the other side closed, got %#v", err)
+	}
+	if _, err := st.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected io.EOF reading once the other side closed, got %#v", err)
+	}
+	if _, err := host.Open(); err != errSessionClosed {
+		t.Errorf("Expected errSessionClosed opening stream on closed session, got %#v", err)
+	}
+}
+
+func TestSessionAttach(t *testing.T) {
+	host, plugin := sessionPair(t)
+	st, ref, err := host.OpenRef()
+	if err != nil {
+		t.Fatalf("Unexpected error from OpenRef: %#v", err)
+	}
+	go func() {
+		st.Write([]byte("hello"))
+		st.Close()
+	}()
+	var attached *Stream
+	if !eventually(func() bool {
+		attached, err = plugin.Attach(ref)
+		return err == nil
+	}) {
+		t.Fatalf("Expected stream to be attached, got %#v", err)
+	}
+	b, err := ioutil.ReadAll(attached)
+	if err != nil || string(b) != "hello" {
+		t.Errorf("Expected %q from stream until EOF, got %q, %#v", "hello", b, err)
+	}
+	if _, err := plugin.Attach(ref); err == nil {
+		t.Error("Expected error attaching stream a second time")
+	}
+
+	// Streams passed by reference aren't accepted.
+	select {
+	case st := <-plugin.accept:
+		t.Errorf("Expected no stream to be accepted, got %d", st.ID())
+	default:
+	}
+}
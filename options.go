@@ -0,0 +1,318 @@
+package pie
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Option configures how a plugin application is started and stopped.  Options
+// are passed to StartProviderWith, StartConsumerWith, StartProviderCmd and
+// StartConsumerCmd.
+type Option func(*config)
+
+// config holds the settings made by Options.
+type config struct {
+	ctx   context.Context
+	codec func(io.ReadWriteCloser) rpc.ClientCodec
+	stop  []StopStep
+
+	env         []string
+	dir         string
+	sysProcAttr *syscall.SysProcAttr
+	extraFiles  []*os.File
+	socket      bool
+	handshake   *Handshake
+	stderrLines int
+
+	sums      []string
+	allowlist string
+	signer    ed25519.PublicKey
+
+	limits  Limits
+	cgroup  *Cgroup
+	sandbox *Sandbox
+	policy  *Policy
+
+	streams bool
+
+	// provider is set when starting a provider plugin.
+	provider bool
+}
+
+// newConfig returns the config made by applying opts to the defaults.
+func newConfig(opts []Option) *config {
+	cfg := &config{ctx: context.Background(), stderrLines: defaultStderrLines}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// apply sets up cmd according to cfg.
+func (cfg *config) apply(cmd *exec.Cmd) {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	// Tell the plugin how long it has to shut down once it's asked to stop.
+	cmd.Env = append(cmd.Env, graceEnv+"="+graceFor(cfg.stop))
+	cmd.Env = append(cmd.Env, cfg.env...)
+	if cfg.dir != "" {
+		cmd.Dir = cfg.dir
+	}
+	if cfg.sysProcAttr != nil {
+		cmd.SysProcAttr = cfg.sysProcAttr
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, cfg.extraFiles...)
+	if cfg.handshake != nil {
+		cmd.Env = append(cmd.Env, handshakeEnv+"=1")
+	}
+	if cfg.streams && cfg.provider {
+		cmd.Env = append(cmd.Env, streamsEnv+"=1")
+	}
+}
+
+// needsCmd reports whether cfg has options that can only be applied to an
+// exec.Cmd.
+func (cfg *config) needsCmd() bool {
+	return cfg.verifying() || cfg.limits != (Limits{}) || cfg.cgroup != nil ||
+		cfg.sandbox != nil || cfg.policy != nil
+}
+
+// client returns an RPC client that talks over pipe using the configured
+// codec.
+func (cfg *config) client(pipe io.ReadWriteCloser) *rpc.Client {
+	if cfg.codec == nil {
+		return rpc.NewClient(pipe)
+	}
+	return rpc.NewClientWithCodec(cfg.codec(pipe))
+}
+
+// WithContext makes the plugin application stop if ctx is done before the
+// plugin exits on its own, as with StartProviderContext.
+func WithContext(ctx context.Context) Option {
+	return func(cfg *config) { cfg.ctx = ctx }
+}
+
+// WithCodec makes a provider plugin's RPC client use the ClientCodec returned
+// by f, as with StartProviderCodec.  It has no effect on consumer plugins,
+// whose codec is chosen by the Server.
+func WithCodec(f func(io.ReadWriteCloser) rpc.ClientCodec) Option {
+	return func(cfg *config) { cfg.codec = f }
+}
+
+// StopStep is one step in stopping a plugin application.  The process is sent
+// Signal, and then given Wait to exit before the next step is taken.
+type StopStep struct {
+	Signal os.Signal
+	Wait   time.Duration
+}
+
+// WithStopTimeout sets how long the plugin application is given to exit after
+// it is sent os.Interrupt, before it is killed.  The default is one second.
+func WithStopTimeout(d time.Duration) Option {
+	return WithStopSignals(StopStep{Signal: os.Interrupt, Wait: d})
+}
+
+// WithStopSignals sets the sequence of signals used to stop the plugin
+// application, such as os.Interrupt, then syscall.SIGTERM a few seconds later.
+// If the plugin is still running after the last step, it is killed.
+//
+// On unix systems, plugins started from an exec.Cmd run in a process group of
+// their own, and the signals are sent to the whole group, so that processes
+// the plugin started are stopped along with it.  On Linux, once the plugin has
+// exited, anything still running in its group is killed.
+//
+// On Linux, the plugin is also killed if the host dies without stopping it,
+// using Pdeathsig.  Linux sends that signal when the thread that started the
+// plugin exits, rather than the host, which only happens if the plugin was
+// started from a goroutine that called runtime.LockOSThread and then exited
+// without unlocking the thread.  Such hosts should start plugins from another
+// goroutine, or give the plugin's process group themselves, as described for
+// WithSysProcAttr.
+func WithStopSignals(steps ...StopStep) Option {
+	return func(cfg *config) { cfg.stop = steps }
+}
+
+// WithEnv adds environment variables, each of the form "key=value", to the
+// plugin application's environment.  The rest of the plugin's environment is
+// inherited from this application, unless the exec.Cmd passed to
+// StartProviderCmd or StartConsumerCmd sets its own Env.
+func WithEnv(env ...string) Option {
+	return func(cfg *config) { cfg.env = append(cfg.env, env...) }
+}
+
+// WithDir sets the working directory of the plugin application.
+func WithDir(dir string) Option {
+	return func(cfg *config) { cfg.dir = dir }
+}
+
+// WithSysProcAttr sets the operating system specific attributes used to start
+// the plugin application.  Unless attr sets Setpgid or Setsid, the plugin is
+// still started in a process group of its own, and on Linux, with Pdeathsig
+// set to SIGKILL, as described for WithStopSignals.  If attr sets either of
+// them, attr is used as it is.
+func WithSysProcAttr(attr *syscall.SysProcAttr) Option {
+	return func(cfg *config) { cfg.sysProcAttr = attr }
+}
+
+// WithExtraFiles passes additional open files to the plugin application.  As
+// with exec.Cmd's ExtraFiles, entry i becomes file descriptor 3+i.
+func WithExtraFiles(files ...*os.File) Option {
+	return func(cfg *config) { cfg.extraFiles = append(cfg.extraFiles, files...) }
+}
+
+// StartProviderWith is like StartProvider, but configures the plugin
+// application with the given options.  Its RPC client uses gob encoding unless
+// a codec is given using WithCodec.
+func StartProviderWith(output io.Writer, path string, args []string, opts ...Option) (*Plugin, error) {
+	cfg := newConfig(opts)
+	cfg.provider = true
+	pipe, err := launch(makeCommand(output, path, args), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.provide(pipe), nil
+}
+
+// StartConsumerWith is like StartConsumer, but configures the plugin
+// application with the given options.
+func StartConsumerWith(output io.Writer, path string, args []string, opts ...Option) (Server, error) {
+	cfg := newConfig(opts)
+	pipe, err := launch(makeCommand(output, path, args), cfg)
+	if err != nil {
+		return Server{}, err
+	}
+	return newServer(pipe), nil
+}
+
+// StartProviderCmd starts a provider-style plugin application using cmd, which
+// the caller may have set up with whatever environment, working directory and
+// other attributes the plugin needs, and returns a Plugin whose RPC client
+// communicates with the plugin over its Stdin and Stdout.  The caller must not
+// set cmd's Stdin or Stdout, but should set its Stderr to receive output from
+// the plugin.  Closing the Plugin returned from this function will shut down
+// the plugin application.
+func StartProviderCmd(cmd *exec.Cmd, opts ...Option) (*Plugin, error) {
+	cfg := newConfig(opts)
+	cfg.provider = true
+	pipe, err := launch(execCmd{cmd}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.provide(pipe), nil
+}
+
+// StartConsumerCmd starts a consumer-style plugin application using cmd, as
+// with StartProviderCmd, and returns the Server for this host application,
+// which should be used to register APIs for the plugin to consume.
+func StartConsumerCmd(cmd *exec.Cmd, opts ...Option) (Server, error) {
+	cfg := newConfig(opts)
+	pipe, err := launch(execCmd{cmd}, cfg)
+	if err != nil {
+		return Server{}, err
+	}
+	return newServer(pipe), nil
+}
+
+// launch is like start, but applies cfg to the process.  If cfg's context is
+// already done, or the executable fails verification, the process is never
+// started.
+func launch(cmd commander, cfg *config) (ioPipe, error) {
+	if err := cfg.ctx.Err(); err != nil {
+		return ioPipe{}, err
+	}
+	var tail *stderrTail
+	var cg *cgroupDir
+	// cleanups are run once the plugin has exited, or failed to start.
+	var cleanups []func()
+	cleanup := func() {
+		for _, f := range cleanups {
+			f()
+		}
+	}
+	var err error
+	if c, ok := cmd.(execCmd); ok {
+		if c.Err != nil {
+			return ioPipe{}, notFound(c.Err)
+		}
+		cfg.apply(c.Cmd)
+		// The executable that is verified has to be the one that runs, which
+		// is found relative to the plugin's working directory.
+		if c.Path, err = executable(c.Cmd); err != nil {
+			return ioPipe{}, err
+		}
+		if err := cfg.verify(c.Path); err != nil {
+			return ioPipe{}, err
+		}
+		setProcGroup(c)
+		f, err := cfg.shim(c)
+		if err != nil {
+			return ioPipe{}, err
+		}
+		if f != nil {
+			cleanups = append(cleanups, f)
+		}
+		if cfg.cgroup != nil {
+			if cg, err = newCgroup(c, *cfg.cgroup); err != nil {
+				cleanup()
+				return ioPipe{}, err
+			}
+			cleanups = append(cleanups, cg.remove)
+		}
+		if cfg.stderrLines > 0 {
+			if tail, err = newStderrTail(c, cfg.stderrLines); err != nil {
+				cleanup()
+				return ioPipe{}, err
+			}
+		}
+	} else if cfg.needsCmd() {
+		return ioPipe{}, errors.New("plugin verification, limits, sandboxing and policies require an exec.Cmd")
+	}
+	var pipe ioPipe
+	if cfg.socket {
+		pipe, err = startSocket(cmd)
+	} else {
+		pipe, err = start(cmd)
+	}
+	tail.started()
+	cg.started()
+	if err != nil {
+		cleanup()
+		return ioPipe{}, err
+	}
+	pipe.stop = cfg.stop
+	pipe.stderr = tail
+	if len(cleanups) > 0 {
+		go func() {
+			<-pipe.exit.done
+			cleanup()
+		}()
+	}
+	if cfg.ctx.Done() != nil {
+		go func() {
+			select {
+			case <-cfg.ctx.Done():
+				pipe.closeProc(cfg.ctx.Err())
+			case <-pipe.exit.done:
+			}
+		}()
+	}
+	if cfg.handshake != nil {
+		hello := *cfg.handshake
+		if hello.Codec == "" && cfg.codec == nil && cfg.provider {
+			hello.Codec = "gob"
+		}
+		if pipe.info, err = shake(pipe, hello); err != nil {
+			pipe.Close()
+			return ioPipe{}, err
+		}
+	}
+	return pipe, nil
+}
@@ -0,0 +1,219 @@
+package pie
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStopSignalsEscalate(t *testing.T) {
+	p := &signalProc{proc: proc{running: make(chan struct{})}, exitOn: syscall.SIGTERM}
+	iop := newIOPipe(&closeRW{}, &closeRW{}, p)
+	iop.stop = []StopStep{
+		{Signal: os.Interrupt, Wait: time.Millisecond},
+		{Signal: syscall.SIGTERM, Wait: time.Second},
+	}
+	if err := iop.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	expected := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	if len(p.sigs) != len(expected) || p.sigs[0] != expected[0] || p.sigs[1] != expected[1] {
+		t.Fatalf("Expected signals %v to be sent, got %v", expected, p.sigs)
+	}
+	if p.killed {
+		t.Fatal("Kill() called unexpectedly on process.")
+	}
+}
+
+func TestStopSignalsTimeout(t *testing.T) {
+	p := &signalProc{proc: proc{running: make(chan struct{})}}
+	iop := newIOPipe(&closeRW{}, &closeRW{}, p)
+	iop.stop = []StopStep{
+		{Signal: os.Interrupt, Wait: time.Millisecond},
+		{Signal: syscall.SIGTERM, Wait: time.Millisecond},
+	}
+	err := iop.Close()
+	if !errors.Is(err, ErrStopTimeout) {
+		t.Fatalf("Expected ErrStopTimeout from Close, got %#v", err)
+	}
+	if !strings.Contains(err.Error(), syscall.SIGTERM.String()) {
+		t.Errorf("Expected error to report the last signal sent, got %q", err)
+	}
+	if !p.killed {
+		t.Fatal("Kill() unexpectedly not called on process.")
+	}
+}
+
+func TestStartProviderWithStopSignals(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("ignore-interrupt"),
+		WithStopSignals(
+			StopStep{Signal: os.Interrupt, Wait: 50 * time.Millisecond},
+			StopStep{Signal: syscall.SIGTERM, Wait: 5 * time.Second},
+		))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	var pid int
+	if err := p.Call("helper.PID", 0, &pid); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	state, _ := p.Wait()
+	if ws, ok := state.Sys().(syscall.WaitStatus); !ok || ws.Signal() != syscall.SIGTERM {
+		t.Fatalf("Expected plugin to be stopped by SIGTERM, got %s", state)
+	}
+}
+
+func TestStartProviderWithCodec(t *testing.T) {
+	f := &fakeCmdData{
+		stdout: idleReader(),
+		stdin:  nopWCloser{ioutil.Discard},
+		p:      &proc{},
+	}
+	old := makeCommand
+	makeCommand = f.makeCommand
+	defer func() { makeCommand = old }()
+
+	tcc := &testClientCodec{}
+	p, err := StartProviderWith(nil, "foo", nil, WithCodec(tcc.NewClientCodec))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	defer p.Close()
+	if !tcc.called {
+		t.Fatal("NewClientCodec function never called.")
+	}
+	if p.Client == nil {
+		t.Fatal("Unexpected nil rpc Client")
+	}
+}
+
+// signalProc is a proc that records every signal it is sent, and only exits
+// when it is sent exitOn.
+type signalProc struct {
+	proc
+	exitOn os.Signal
+	sigs   []os.Signal
+}
+
+func (p *signalProc) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sigs = append(p.sigs, sig)
+	if sig == p.exitOn {
+		p.exit()
+	}
+	return nil
+}
+
+func TestStartProviderCmd(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], helperArgs("provider")...)
+	cmd.Env = append(os.Environ(), helperEnv+"=1", "PIE_TEST_CMD=cmd")
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	p, err := StartProviderCmd(cmd, WithEnv("PIE_TEST_OPTION=option"))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderCmd: %#v", err)
+	}
+	defer p.Close()
+
+	for key, expected := range map[string]string{
+		"PIE_TEST_CMD":    "cmd",
+		"PIE_TEST_OPTION": "option",
+	} {
+		var value string
+		if err := p.Call("helper.Getenv", key, &value); err != nil {
+			t.Fatalf("Unexpected error from Call: %#v", err)
+		}
+		if value != expected {
+			t.Errorf("Expected plugin's %s to be %q, got %q", key, expected, value)
+		}
+	}
+	var wd string
+	if err := p.Call("helper.Getwd", 0, &wd); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if !sameFile(t, wd, dir) {
+		t.Errorf("Expected plugin's working directory to be %q, got %q", dir, wd)
+	}
+}
+
+func TestStartProviderWithDir(t *testing.T) {
+	dir := t.TempDir()
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"),
+		WithEnv(helperEnv+"=1"), WithDir(dir))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	defer p.Close()
+
+	var wd string
+	if err := p.Call("helper.Getwd", 0, &wd); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if !sameFile(t, wd, dir) {
+		t.Errorf("Expected plugin's working directory to be %q, got %q", dir, wd)
+	}
+}
+
+func TestStartConsumerCmd(t *testing.T) {
+	cmd := exec.Command(os.Args[0], helperArgs("consumer")...)
+	cmd.Stderr = os.Stderr
+	s, err := StartConsumerCmd(cmd, WithEnv(helperEnv+"=1"))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartConsumerCmd: %#v", err)
+	}
+	api := recordAPI{make(chan string, 1)}
+	s.RegisterName("api", api)
+
+	serveConsumer(t, s)
+	select {
+	case name := <-api.called:
+		if name != "plugin" {
+			t.Fatalf("Expected consumer plugin to say hi to %q, got %q", "plugin", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Consumer plugin did not call the host")
+	}
+}
+
+func TestStartProviderCmdStdoutSet(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Stdout = ioutil.Discard
+	if _, err := StartProviderCmd(cmd); err == nil {
+		t.Fatal("Expected error from StartProviderCmd when Stdout is already set")
+	}
+}
+
+// sameFile reports whether the two paths refer to the same file.
+func sameFile(t *testing.T, a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(fa, fb)
+}
+
+// recordAPI is an API that records the names it is called with.
+type recordAPI struct {
+	called chan string
+}
+
+func (r recordAPI) SayHi(name string, response *string) error {
+	r.called <- name
+	*response = "Hi " + name
+	return nil
+}
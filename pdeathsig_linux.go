@@ -0,0 +1,11 @@
+package pie
+
+import "syscall"
+
+// setPdeathsig makes the plugin application be killed if the host dies
+// without stopping it, unless attr already says otherwise.
+func setPdeathsig(attr *syscall.SysProcAttr) {
+	if attr.Pdeathsig == 0 {
+		attr.Pdeathsig = syscall.SIGKILL
+	}
+}
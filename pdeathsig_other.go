@@ -0,0 +1,9 @@
+//go:build unix && !linux
+
+package pie
+
+import "syscall"
+
+// setPdeathsig does nothing, because a process can only ask to be killed when
+// its parent dies on Linux.
+func setPdeathsig(attr *syscall.SysProcAttr) {}
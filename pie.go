@@ -7,27 +7,54 @@ import (
 	"net/rpc"
 	"os"
 	"os/exec"
+	"reflect"
+	"sync"
 	"time"
 )
 
-var errProcStopTimeout = errors.New("process killed after timeout waiting for process to stop")
-
 // NewProvider returns a Server that will serve RPC over this
-// application's Stdin and Stdout.  This method is intended to be run by the
-// plugin application.
+// application's Stdin and Stdout, or over the socket passed by the host if it
+// started this application using WithSocketTransport.  This method is
+// intended to be run by the plugin application.
+//
+// When serving over Stdout, the real Stdout is reserved for RPC, and os.Stdout
+// is redirected to Stderr (on unix systems), so that anything else the plugin
+// prints ends up in the host's output instead of corrupting the RPC stream.
+// NewConsumer and NewConsumerCodec do the same.
+//
+// While the Server is serving, it catches os.Interrupt, which the host sends
+// when it closes the Plugin, and shuts itself down gracefully as described for
+// Shutdown, so that Serve returns rather than the plugin being killed by the
+// signal.  The plugin should exit once Serve returns.  Interrupts are left
+// alone if the plugin ignores them, using signal.Ignore, before serving.
 func NewProvider() Server {
-	return Server{
-		server: rpc.NewServer(),
-		rwc:    rwCloser{os.Stdin, os.Stdout},
-	}
+	rwc, sess := providerConn(stdio())
+	s := newServer(rwc)
+	s.state.signals = true
+	s.session = sess
+	return s
 }
 
 // Server is a type that represents an RPC server that serves an API over
 // stdin/stdout.
 type Server struct {
-	server *rpc.Server
-	rwc    io.ReadWriteCloser
-	codec  rpc.ServerCodec
+	server   *rpc.Server
+	rwc      io.ReadWriteCloser
+	codec    rpc.ServerCodec
+	services *serviceNames
+	state    *serverState
+	// session is set for bidirectional plugins.
+	session *Session
+}
+
+// newServer returns a Server that serves over rwc.
+func newServer(rwc io.ReadWriteCloser) Server {
+	return Server{
+		server:   rpc.NewServer(),
+		rwc:      rwc,
+		services: &serviceNames{},
+		state:    newServerState(),
+	}
 }
 
 // Close closes the connection with the client.  If the client is a plugin
@@ -41,15 +68,33 @@ func (s Server) Close() error {
 }
 
 // Serve starts the Server's RPC server, serving via gob encoding.  This call
-// will block until the client hangs up.
+// will block until the client hangs up, or the Server is shut down, which for
+// a Server returned by NewProvider includes being sent os.Interrupt.  If the
+// host started this plugin application using WithHandshake, Serve first
+// answers the handshake, and if that fails, it closes the connection and
+// returns.
 func (s Server) Serve() {
-	s.server.ServeConn(s.rwc)
+	s.serve("gob", newGobServerCodec)
 }
 
 // ServeCodec starts the Server's RPC server, serving via the encoding returned
-// by f. This call will block until the client hangs up.
+// by f. This call will block until the client hangs up, or the Server is shut
+// down.  The handshake is answered as with Serve, using the codec named by
+// SetPluginInfo, if any.
 func (s Server) ServeCodec(f func(io.ReadWriteCloser) rpc.ServerCodec) {
-	s.server.ServeCodec(f(s.rwc))
+	s.serve("", f)
+}
+
+// handshake answers the host's handshake, if any, and reports whether serving
+// should continue.
+func (s Server) handshake(codec string) bool {
+	err := answerHandshake(s.rwc, codec, s.services.list())
+	if err != nil {
+		logHandshake(err)
+		s.rwc.Close()
+		return false
+	}
+	return true
 }
 
 // Register publishes in the provider the set of methods of the receiver value
@@ -65,45 +110,70 @@ func (s Server) ServeCodec(f func(io.ReadWriteCloser) rpc.ServerCodec) {
 // accesses each method using a string of the form "Type.Method", where Type is
 // the receiver's concrete type.
 func (s Server) Register(rcvr interface{}) error {
-	return s.server.Register(rcvr)
+	if err := s.server.Register(rcvr); err != nil {
+		return err
+	}
+	s.services.add(reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name())
+	return nil
 }
 
 // RegisterName is like Register but uses the provided name for the type
 // instead of the receiver's concrete type.
 func (s Server) RegisterName(name string, rcvr interface{}) error {
-	return s.server.RegisterName(name, rcvr)
+	if err := s.server.RegisterName(name, rcvr); err != nil {
+		return err
+	}
+	s.services.add(name)
+	return nil
 }
 
-// StartProvider start a provider-style plugin application at the given path and
-// args, and returns an RPC client that communicates with the plugin using gob
-// encoding over the plugin's Stdin and Stdout.  The writer passed to output
-// will receive output from the plugin's stderr.  Closing the RPC client
-// returned from this function will shut down the plugin application.
-func StartProvider(output io.Writer, path string, args ...string) (*rpc.Client, error) {
-	pipe, err := start(makeCommand(output, path, args))
-	if err != nil {
-		return nil, err
+// serviceNames records the names of the services registered with a Server, so
+// they can be reported to the host during the handshake.
+type serviceNames struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (n *serviceNames) add(name string) {
+	if n == nil {
+		return
 	}
-	return rpc.NewClient(pipe), nil
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.names = append(n.names, name)
+}
+
+func (n *serviceNames) list() []string {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.names...)
+}
+
+// StartProvider start a provider-style plugin application at the given path and
+// args, and returns a Plugin whose RPC client communicates with the plugin using
+// gob encoding over the plugin's Stdin and Stdout.  The writer passed to output
+// will receive output from the plugin's stderr.  Closing the Plugin returned
+// from this function will shut down the plugin application.
+func StartProvider(output io.Writer, path string, args ...string) (*Plugin, error) {
+	return StartProviderWith(output, path, args)
 }
 
 // StartProviderCodec starts a provider-style plugin application at the given
-// path and args, and returns an RPC client that communicates with the plugin
-// using the ClientCodec returned by f over the plugin's Stdin and Stdout. The
-// writer passed to output will receive output from the plugin's stderr.
-// Closing the RPC client returned from this function will shut down the plugin
-// application.
+// path and args, and returns a Plugin whose RPC client communicates with the
+// plugin using the ClientCodec returned by f over the plugin's Stdin and
+// Stdout. The writer passed to output will receive output from the plugin's
+// stderr. Closing the Plugin returned from this function will shut down the
+// plugin application.
 func StartProviderCodec(
 	f func(io.ReadWriteCloser) rpc.ClientCodec,
 	output io.Writer,
 	path string,
 	args ...string,
-) (*rpc.Client, error) {
-	pipe, err := start(makeCommand(output, path, args))
-	if err != nil {
-		return nil, err
-	}
-	return rpc.NewClientWithCodec(f(pipe)), nil
+) (*Plugin, error) {
+	return StartProviderWith(output, path, args, WithCodec(f))
 }
 
 // StartConsumer starts a consumer-style plugin application with the given path
@@ -111,27 +181,30 @@ func StartProviderCodec(
 // application provides.  The function returns the Server for this host
 // application, which should be used to register APIs for the plugin to consume.
 func StartConsumer(output io.Writer, path string, args ...string) (Server, error) {
-	pipe, err := start(makeCommand(output, path, args))
-	if err != nil {
-		return Server{}, err
-	}
-	return Server{
-		server: rpc.NewServer(),
-		rwc:    pipe,
-	}, nil
+	return StartConsumerWith(output, path, args)
 }
 
 // NewConsumer returns an rpc.Client that will consume an API from the host
-// process over this application's Stdin and Stdout using gob encoding.
+// process over this application's Stdin and Stdout using gob encoding.  If the
+// host started this application using WithSocketTransport, the socket it
+// passed is used instead of Stdin and Stdout.  If the host started this
+// application using WithHandshake, NewConsumer answers the handshake before
+// returning.
 func NewConsumer() *rpc.Client {
-	return rpc.NewClient(rwCloser{os.Stdin, os.Stdout})
+	rwc := stdio()
+	logHandshake(answerHandshake(rwc, "gob", nil))
+	return rpc.NewClient(rwc)
 }
 
 // NewConsumerCodec returns an rpc.Client that will consume an API from the host
 // process over this application's Stdin and Stdout using the ClientCodec
-// returned by f.
+// returned by f.  If the host started this application using
+// WithSocketTransport, the socket it passed is used instead of Stdin and
+// Stdout.
 func NewConsumerCodec(f func(io.ReadWriteCloser) rpc.ClientCodec) *rpc.Client {
-	return rpc.NewClientWithCodec(f(rwCloser{os.Stdin, os.Stdout}))
+	rwc := stdio()
+	logHandshake(answerHandshake(rwc, "", nil))
+	return rpc.NewClientWithCodec(f(rwc))
 }
 
 // start runs the plugin and returns an ioPipe that can be used to control the
@@ -158,9 +231,9 @@ func start(cmd commander) (_ ioPipe, err error) {
 
 	proc, err := cmd.Start()
 	if err != nil {
-		return ioPipe{}, err
+		return ioPipe{}, notFound(err)
 	}
-	return ioPipe{out, in, proc}, nil
+	return newIOPipe(out, in, proc), nil
 }
 
 // makeCommand is a function that just creates an exec.Cmd and the process in
@@ -179,9 +252,19 @@ func (e execCmd) Start() (osProcess, error) {
 	if err := e.Cmd.Start(); err != nil {
 		return nil, err
 	}
+	if inOwnGroup(e.SysProcAttr) {
+		return procGroup{e.Cmd.Process}, nil
+	}
 	return e.Cmd.Process, nil
 }
 
+// procGroup is a process that leads a process group of its own.  On systems
+// with process groups, signalling or killing it does the same to every process
+// in the group.
+type procGroup struct {
+	*os.Process
+}
+
 // commander is an interface that is fulfilled by exec.Cmd and makes our testing
 // a little easier.
 type commander interface {
@@ -206,41 +289,141 @@ type ioPipe struct {
 	io.ReadCloser
 	io.WriteCloser
 	proc osProcess
+	exit *procExit
+	// stop is how to stop the process.  If empty, the process is sent
+	// os.Interrupt and given procTimeout to exit.
+	stop []StopStep
+	// info is how the plugin described itself during the handshake.
+	info PluginInfo
+	// stderr keeps the last lines of the process' stderr, if enabled.
+	stderr *stderrTail
+}
+
+// newIOPipe returns an ioPipe for the given process, and starts waiting for the
+// process to exit.
+func newIOPipe(r io.ReadCloser, w io.WriteCloser, proc osProcess) ioPipe {
+	return ioPipe{ReadCloser: r, WriteCloser: w, proc: proc, exit: waitProc(proc)}
 }
 
 // Close closes the pipe's WriteCloser, ReadClosers, and process.
 func (iop ioPipe) Close() error {
+	// The process may exit as soon as its pipes are closed, so it has to be
+	// known beforehand that the host stopped it.
+	iop.exit.stop(ErrClosed)
 	err := iop.ReadCloser.Close()
 	if writeErr := iop.WriteCloser.Close(); writeErr != nil {
 		err = writeErr
 	}
-	if procErr := iop.closeProc(); procErr != nil {
+	if procErr := iop.closeProc(ErrClosed); procErr != nil {
 		err = procErr
 	}
 	return err
 }
 
+// pid returns the process id of the pipe's process, or 0 if it is unknown.
+func (iop ioPipe) pid() int {
+	switch p := iop.proc.(type) {
+	case *os.Process:
+		return p.Pid
+	case procGroup:
+		return p.Pid
+	}
+	return 0
+}
+
 // procTimeout is the timeout to wait for a process to stop after being
 // signalled.  It is adjustable to keep tests fast.
 var procTimeout = time.Second
 
-// closeProc sends an interrupt signal to the pipe's process, and if it doesn't
-// respond in one second, kills the process.
-func (iop ioPipe) closeProc() error {
-	result := make(chan error, 1)
-	go func() { _, err := iop.proc.Wait(); result <- err }()
-	if err := iop.proc.Signal(os.Interrupt); err != nil {
-		return err
+// closeProc stops the pipe's process by taking each of the pipe's stop steps
+// in turn, and killing the process if it is still running after the last one.
+// By default, that means sending an interrupt signal and killing the process
+// if it doesn't respond in one second.  If the process leads its own process
+// group, the whole group is signalled, and where the system allows, anything
+// left running in the group once the process has exited is killed, as
+// described for procGroup.Wait.  The cause is recorded as the reason the
+// process stopped.  If the process had already exited on its own, and not
+// successfully, closeProc returns an *ExitError saying how.
+func (iop ioPipe) closeProc(cause error) error {
+	iop.exit.stop(cause)
+	steps := iop.stop
+	if len(steps) == 0 {
+		steps = []StopStep{{Signal: os.Interrupt, Wait: procTimeout}}
 	}
-	select {
-	case err := <-result:
-		return err
-	case <-time.After(procTimeout):
-		if err := iop.proc.Kill(); err != nil {
-			return fmt.Errorf("error killing process after timeout: %s", err)
+	for _, step := range steps {
+		// The process may have already exited, in which case there's nothing
+		// to signal, and we just report how it exited.
+		if err := iop.proc.Signal(step.Signal); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return fmt.Errorf("error sending %s to process: %w", step.Signal, err)
 		}
-		return errProcStopTimeout
+		select {
+		case <-iop.exit.done:
+			if iop.exit.failed() {
+				// The process had already exited on its own.
+				return iop.exit.exitError(iop.stderr)
+			}
+			return iop.exit.err
+		case <-time.After(step.Wait):
+		}
+	}
+	if err := iop.proc.Kill(); err != nil {
+		return fmt.Errorf("error killing process after timeout: %w", err)
+	}
+	return &StopTimeoutError{Steps: steps, Stderr: iop.stderr.last()}
+}
+
+// procExit waits for a process to exit and records how it exited.  A process
+// may only be waited on once, so every copy of an ioPipe shares the same
+// procExit.
+type procExit struct {
+	// done is closed when the process has exited.  The fields below it may
+	// only be read after done is closed.
+	done  chan struct{}
+	state *os.ProcessState
+	err   error
+	// stopped is why the host stopped the process, or nil if the process
+	// exited on its own.
+	stopped error
+
+	mu    sync.Mutex
+	cause error
+}
+
+// waitProc returns a procExit that will record the exit of proc.
+func waitProc(proc osProcess) *procExit {
+	e := &procExit{done: make(chan struct{})}
+	go func() {
+		e.state, e.err = proc.Wait()
+		e.mu.Lock()
+		e.stopped = e.cause
+		e.mu.Unlock()
+		close(e.done)
+	}()
+	return e
+}
+
+// failed reports whether the process exited on its own, rather than being
+// stopped by the host, and didn't exit successfully.  It may only be called
+// once done is closed.
+func (e *procExit) failed() bool {
+	return e.stopped == nil && (e.err != nil || e.state != nil && !e.state.Success())
+}
+
+// exitError returns the error for a process that exited on its own, including
+// the last lines it wrote to stderr.  It may only be called once done is
+// closed.
+func (e *procExit) exitError(stderr *stderrTail) *ExitError {
+	return &ExitError{State: e.state, Err: e.err, Stderr: stderr.last()}
+}
+
+// stop records that the host has asked the process to stop, and why.  Only
+// the first cause is kept.
+func (e *procExit) stop(cause error) {
+	e.mu.Lock()
+	if e.cause == nil {
+		e.cause = cause
 	}
+	e.mu.Unlock()
 }
 
 // rwCloser just merges a ReadCloser and a WriteCloser into a ReadWriteCloser.
@@ -101,7 +101,7 @@ func TestIOPipeClose(t *testing.T) {
 	rc := &closeRW{}
 	wc := &closeRW{}
 	p := &proc{}
-	iop := ioPipe{rc, wc, p}
+	iop := newIOPipe(rc, wc, p)
 	if err := iop.Close(); err != nil {
 		t.Errorf("Unexpected error from ioPipe.Close: %#v", err)
 	}
@@ -130,9 +130,9 @@ func TestIOPipeSlowProc(t *testing.T) {
 	rc := &closeRW{}
 	wc := &closeRW{}
 	p := &proc{delay: procTimeout * 2}
-	iop := ioPipe{rc, wc, p}
-	if err := iop.Close(); err != errProcStopTimeout {
-		t.Errorf("Unexpected error from ioPipe.Close, expected %#v, got: %#v", errProcStopTimeout, err)
+	iop := newIOPipe(rc, wc, p)
+	if err := iop.Close(); !errors.Is(err, ErrStopTimeout) {
+		t.Errorf("Unexpected error from ioPipe.Close, expected %#v, got: %#v", ErrStopTimeout, err)
 	}
 	if !rc.closed {
 		t.Error("Close not called on ReadCloser.")
@@ -245,7 +245,7 @@ func testServeAndStart(
 	output := &bytes.Buffer{}
 	path := "foo"
 	args := []string{"bar", "baz"}
-	var client *rpc.Client
+	var client *Plugin
 	var err error
 	if clientcodec == nil {
 		client, err = StartProvider(output, path, args...)
@@ -542,6 +542,11 @@ type proc struct {
 	sig       os.Signal
 	killed    bool
 	waited    bool
+
+	// if running is non-nil, Wait will not return until it is closed, which
+	// happens when the process is signalled or killed.
+	running chan struct{}
+	stop    sync.Once
 }
 
 // Wait will wait for delay time and then return waitErr.
@@ -549,6 +554,9 @@ func (p *proc) Wait() (*os.ProcessState, error) {
 	p.mu.Lock()
 	p.waited = true
 	p.mu.Unlock()
+	if p.running != nil {
+		<-p.running
+	}
 	<-time.After(p.delay)
 	return nil, p.waitErr
 }
@@ -558,17 +566,27 @@ func (p *proc) Kill() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.killed = true
+	p.exit()
 	return p.killErr
 }
 
-// Signal ignores the signal and returns signalErr.
+// Signal records the signal, stops the process if it is running, and returns
+// signalErr.
 func (p *proc) Signal(sig os.Signal) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.sig = sig
+	p.exit()
 	return p.signalErr
 }
 
+// exit lets Wait return if the process is running.
+func (p *proc) exit() {
+	if p.running != nil {
+		p.stop.Do(func() { close(p.running) })
+	}
+}
+
 // closeRW is a helper that fulfills io.Reader, io.Writer, and io.Closer for
 // testing purposes.
 type closeRW struct {
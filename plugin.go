@@ -0,0 +1,175 @@
+package pie
+
+import (
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"time"
+)
+
+// Plugin is a handle to a running provider-style plugin application.  It embeds
+// the RPC client used to call the plugin's API, and also exposes the plugin's
+// process, so the host can tell when and why the plugin stopped running rather
+// than discovering it via rpc.ErrShutdown on the next call.
+//
+// The last lines the plugin wrote to stderr are kept, so that the errors
+// returned when it exits unexpectedly show what it had to say about it.  See
+// WithStderrLines.
+//
+// Closing the Plugin shuts down the plugin application.  A Plugin whose
+// application has exited on its own must still be closed, to close the pipes
+// to it.  If it didn't exit successfully, Close then returns an *ExitError
+// saying how it exited.
+type Plugin struct {
+	*rpc.Client
+	pid    int
+	exit   *procExit
+	info   PluginInfo
+	stderr *stderrTail
+	// session is set for bidirectional plugins.
+	session *Session
+}
+
+// newPlugin returns a Plugin that uses client to talk to the process
+// controlled by pipe.
+func newPlugin(client *rpc.Client, pipe ioPipe) *Plugin {
+	return &Plugin{
+		Client: client,
+		pid:    pipe.pid(),
+		exit:   pipe.exit,
+		info:   pipe.info,
+		stderr: pipe.stderr,
+	}
+}
+
+// Call invokes the named function on the plugin, waits for it to complete, and
+// returns its error status.  If the plugin exits while the call is in
+// progress, the returned error wraps both the RPC client's error and the
+// error from Err, which includes the last lines the plugin wrote to stderr.
+func (p *Plugin) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return p.callErr(serviceMethod, p.Client.Call(serviceMethod, args, reply))
+}
+
+// Stderr returns the last lines the plugin application wrote to stderr, or an
+// empty string if they weren't kept.
+func (p *Plugin) Stderr() string {
+	return p.stderr.String()
+}
+
+// PID returns the process id of the plugin application.
+func (p *Plugin) PID() int {
+	return p.pid
+}
+
+// Done returns a channel that is closed when the plugin application exits,
+// whether because it was closed by the host or because it stopped on its own.
+func (p *Plugin) Done() <-chan struct{} {
+	return p.exit.done
+}
+
+// ExitCode returns the exit code of the plugin application once it has exited,
+// or -1 if it is still running or was terminated by a signal.
+func (p *Plugin) ExitCode() int {
+	select {
+	case <-p.exit.done:
+	default:
+		return -1
+	}
+	if p.exit.state == nil {
+		return -1
+	}
+	return p.exit.state.ExitCode()
+}
+
+// Wait blocks until the plugin application exits, and returns its
+// ProcessState and any error encountered while waiting for it.  If the plugin
+// exited on its own, and didn't exit successfully, the error is an *ExitError
+// including the last lines the plugin wrote to stderr; as with exec.Cmd's
+// Wait, a successful exit isn't an error.  Unlike os.Process.Wait, Wait may be
+// called any number of times, from any number of goroutines.
+func (p *Plugin) Wait() (*os.ProcessState, error) {
+	<-p.exit.done
+	if p.exit.failed() {
+		return p.exit.state, p.exit.exitError(p.stderr)
+	}
+	return p.exit.state, p.exit.err
+}
+
+// Err returns nil while the plugin application is running.  Once Done is
+// closed, Err returns a non-nil error explaining why the plugin exited.  If the
+// plugin exited on its own, Err returns an *ExitError.  If the plugin was
+// stopped by closing the Plugin, Err returns ErrClosed.  If it was
+// stopped because the context passed to StartProviderContext was done, Err
+// returns the context's error.
+func (p *Plugin) Err() error {
+	select {
+	case <-p.exit.done:
+	default:
+		return nil
+	}
+	if p.exit.stopped != nil {
+		return p.exit.stopped
+	}
+	return p.exit.exitError(p.stderr)
+}
+
+// stoppedByHost reports whether the plugin exited because the host stopped it.
+func (p *Plugin) stoppedByHost() bool {
+	<-p.exit.done
+	return p.exit.stopped != nil
+}
+
+// exitWait is how long to wait for a plugin to exit once it is known to be
+// going: after its connection went away during a call, so that the call's
+// error can say why it exited, or after Manager.StopAll has closed it.
+var exitWait = time.Second
+
+// callErr returns the error for a call that failed with err.  If the call
+// failed because the plugin went away while it was in progress, the error
+// also says why the plugin exited.
+func (p *Plugin) callErr(serviceMethod string, err error) error {
+	if err != rpc.ErrShutdown && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	select {
+	case <-p.Done():
+		return fmt.Errorf("call to %s interrupted (%w): %w", serviceMethod, err, p.Err())
+	case <-time.After(exitWait):
+		return err
+	}
+}
+
+// goVia invokes the named function asynchronously, like rpc.Client.Go, on the
+// plugin returned by acquire, for the types that choose which plugin each call
+// is sent to.  If acquire fails, so does the call.  Otherwise release is called
+// once the call has completed, and the call's error says why the plugin exited
+// if it went away while the call was in progress.
+func goVia(acquire func() (*Plugin, func(), error), serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	if done == nil {
+		done = make(chan *rpc.Call, 10)
+	} else if cap(done) == 0 {
+		panic("pie: done channel is unbuffered")
+	}
+	call := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	p, release, err := acquire()
+	if err != nil {
+		call.Error = err
+		call.Done <- call
+		return call
+	}
+	sent := p.Client.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1))
+	go func() {
+		<-sent.Done
+		release()
+		if sent.Error != nil {
+			call.Error = p.callErr(serviceMethod, sent.Error)
+		}
+		select {
+		case call.Done <- call:
+		default:
+			// Like rpc.Client, don't block if the caller's channel is full.
+		}
+	}()
+	return call
+}
@@ -0,0 +1,125 @@
+package pie
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPluginClosedByHost(t *testing.T) {
+	p := &proc{running: make(chan struct{})}
+	pipe := newIOPipe(idleReader(), nopWCloser{ioutil.Discard}, p)
+	plugin := newPlugin(rpc.NewClient(pipe), pipe)
+
+	select {
+	case <-plugin.Done():
+		t.Fatal("Done closed before the plugin exited")
+	default:
+	}
+	if err := plugin.Err(); err != nil {
+		t.Fatalf("Unexpected non-nil error from Err while running: %#v", err)
+	}
+	if code := plugin.ExitCode(); code != -1 {
+		t.Fatalf("Expected exit code -1 while running, got %d", code)
+	}
+	if err := plugin.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	select {
+	case <-plugin.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the plugin was closed")
+	}
+	if err := plugin.Err(); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Err, got %#v", err)
+	}
+}
+
+func TestPluginExitedOnItsOwn(t *testing.T) {
+	waitErr := errors.New("wait")
+	p := &proc{running: make(chan struct{}), waitErr: waitErr}
+	pipe := newIOPipe(idleReader(), nopWCloser{ioutil.Discard}, p)
+	plugin := newPlugin(rpc.NewClient(pipe), pipe)
+	defer plugin.Close()
+
+	p.exit()
+	if _, err := plugin.Wait(); !errors.Is(err, waitErr) {
+		t.Fatalf("Expected error %#v from Wait, got %#v", waitErr, err)
+	}
+	// Wait may be called more than once.
+	if _, err := plugin.Wait(); !errors.Is(err, waitErr) {
+		t.Fatalf("Expected error %#v from second Wait, got %#v", waitErr, err)
+	}
+	err := plugin.Err()
+	if err == nil || err == ErrClosed {
+		t.Fatalf("Expected plugin exit error from Err, got %#v", err)
+	}
+}
+
+func TestPluginExitsOnEOF(t *testing.T) {
+	// Most plugins exit as soon as their input is closed, which may be before
+	// Close gets around to stopping them.
+	p := &proc{running: make(chan struct{})}
+	w := &exitOnClose{Writer: ioutil.Discard, proc: p}
+	pipe := newIOPipe(idleReader(), w, p)
+	w.exit = pipe.exit
+	plugin := newPlugin(rpc.NewClient(pipe), pipe)
+
+	if err := plugin.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	if err := plugin.Err(); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Err, got %#v", err)
+	}
+}
+
+func TestPluginExitCode(t *testing.T) {
+	pipe, err := start(makeCommand(nil, "sh", []string{"-c", "exit 3"}))
+	if err != nil {
+		t.Fatalf("Unexpected error starting process: %#v", err)
+	}
+	plugin := newPlugin(rpc.NewClient(pipe), pipe)
+	defer plugin.Close()
+
+	if plugin.PID() <= 0 {
+		t.Errorf("Expected a process id, got %d", plugin.PID())
+	}
+	state, err := plugin.Wait()
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.State != state {
+		t.Fatalf("Expected *ExitError with the process state from Wait, got %#v", err)
+	}
+	if state.ExitCode() != 3 {
+		t.Errorf("Expected exit code 3, got %d", state.ExitCode())
+	}
+	if code := plugin.ExitCode(); code != 3 {
+		t.Errorf("Expected exit code 3 from ExitCode, got %d", code)
+	}
+	if err := plugin.Err(); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Expected Err to report exit status 3, got %v", err)
+	}
+}
+
+// idleReader returns a ReadCloser that blocks on Read until it is closed.
+func idleReader() io.ReadCloser {
+	r, _ := io.Pipe()
+	return r
+}
+
+// exitOnClose is the input of a process that exits when the input is closed.
+// Close returns once the exit has been recorded.
+type exitOnClose struct {
+	io.Writer
+	proc *proc
+	exit *procExit
+}
+
+func (c *exitOnClose) Close() error {
+	c.proc.exit()
+	<-c.exit.done
+	return nil
+}
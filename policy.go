@@ -0,0 +1,131 @@
+package pie
+
+import "fmt"
+
+// Policy restricts what a plugin application may do, using a seccomp filter
+// for system calls and a Landlock ruleset for files.  Unlike a Sandbox, it
+// doesn't hide anything from the plugin, but it can stop the plugin from
+// doing things it has no business doing, such as opening network connections.
+// A Policy and a Sandbox may be used together.
+type Policy struct {
+	// Syscalls are the names of the only system calls the plugin may make,
+	// such as "read" or "openat".  Other system calls fail with EPERM,
+	// except clone3, which fails with ENOSYS so that C libraries fall back
+	// to clone.  Names of system calls that don't exist on the host's
+	// architecture, such as "open" on arm64, are ignored.  execve is always
+	// allowed, since it is how the plugin is executed.  If Syscalls is
+	// empty, system calls aren't filtered, and OpenReadOnly and ThreadsOnly
+	// have no effect.
+	Syscalls []string
+	// OpenReadOnly allows open and openat, if they aren't in Syscalls, but
+	// only to open files for reading, which programs need to load their
+	// shared libraries.
+	OpenReadOnly bool
+	// ThreadsOnly allows clone, if it isn't in Syscalls, but only to start
+	// threads, so that the plugin can't start other processes.
+	ThreadsOnly bool
+	// Files, if not nil, limits which files the plugin may use.
+	Files *FileAccess
+}
+
+// FileAccess lists the files and directories a plugin application may use,
+// enforced using Landlock.  Access to a directory includes everything
+// beneath it.  Files and directories that aren't listed can't be read,
+// written or executed, apart from the plugin's own executable, which may
+// always be read and executed.  Files the plugin has already opened, such as
+// its stdin and stdout, aren't affected.
+type FileAccess struct {
+	// Read are paths the plugin may read and execute.
+	Read []string
+	// Write are paths the plugin may read, execute, write, create and
+	// remove.
+	Write []string
+}
+
+// ComputeOnly is a Policy for plugins that only compute: they talk to the host
+// and nothing else.  They may read files, such as their shared libraries, but
+// can't write to files they haven't been given, use the network, or start
+// other processes.  It works for Go plugins, and for most others that don't
+// need more than the C library.
+var ComputeOnly = Policy{
+	Syscalls: []string{
+		// Files that are already open, and reading the filesystem.
+		"read", "write", "readv", "writev", "pread64", "pwrite64", "close",
+		"fstat", "stat", "lstat", "newfstatat", "statx", "lseek", "access",
+		"faccessat", "faccessat2", "readlink", "readlinkat", "getdents64",
+		"getcwd", "ioctl", "fcntl", "dup", "dup2", "dup3", "pipe", "pipe2",
+		"eventfd2", "poll", "ppoll", "select", "pselect6", "epoll_create",
+		"epoll_create1", "epoll_ctl", "epoll_wait", "epoll_pwait",
+		"epoll_pwait2",
+		// Memory.
+		"mmap", "munmap", "mprotect", "mremap", "madvise", "brk",
+		"membarrier",
+		// Signals, threads and time.
+		"rt_sigaction", "rt_sigprocmask", "rt_sigreturn", "rt_sigtimedwait",
+		"sigaltstack", "futex", "sched_yield", "sched_getaffinity",
+		"nanosleep", "clock_gettime", "clock_getres", "clock_nanosleep",
+		"gettimeofday", "getpid", "gettid", "getppid", "getuid", "geteuid",
+		"getgid", "getegid", "tgkill", "exit", "exit_group",
+		"restart_syscall",
+		// Process setup.
+		"getrandom", "uname", "getrlimit", "prlimit64", "getrusage",
+		"sysinfo", "arch_prctl", "set_tid_address", "set_robust_list",
+		"rseq",
+	},
+	OpenReadOnly: true,
+	ThreadsOnly:  true,
+}
+
+// ReadOnlyFS is a Policy for plugins that may read and execute any file, but
+// may not write to any file apart from /dev/null.
+var ReadOnlyFS = Policy{
+	Files: &FileAccess{Read: []string{"/"}, Write: []string{"/dev/null"}},
+}
+
+// Profile returns the predefined Policy with the given name: "compute-only"
+// for ComputeOnly, or "read-only-fs" for ReadOnlyFS.  It is meant for hosts
+// that let their configuration choose how plugins are restricted.
+func Profile(name string) (Policy, error) {
+	switch name {
+	case "compute-only":
+		return ComputeOnly, nil
+	case "read-only-fs":
+		return ReadOnlyFS, nil
+	}
+	return Policy{}, fmt.Errorf("unknown policy profile %q", name)
+}
+
+// WithPolicy restricts the plugin application as described by p.  The policy
+// is applied by the same shim as WithLimits, with the same requirements, and
+// like resource limits, it is inherited by the plugin's own child processes.
+// The Start functions return an error that matches errors.ErrUnsupported if
+// the kernel doesn't support the seccomp filters or Landlock rulesets p needs.
+//
+// WithPolicy is only supported on Linux: system call filters need Linux 4.14
+// and later on amd64 or arm64, and file access rules need Linux 5.13 and later
+// with Landlock enabled.
+func WithPolicy(p Policy) Option {
+	return func(cfg *config) { cfg.policy = &p }
+}
+
+// bpfInstruction is an instruction of a classic BPF program, as used by
+// seccomp filters.
+type bpfInstruction struct {
+	Code uint16 `json:"code"`
+	Jt   uint8  `json:"jt"`
+	Jf   uint8  `json:"jf"`
+	K    uint32 `json:"k"`
+}
+
+// landlockRuleset is a Landlock ruleset for the shim to enforce.  Handled is
+// the set of access rights that are denied unless a rule allows them.
+type landlockRuleset struct {
+	Handled uint64         `json:"handled"`
+	Rules   []landlockRule `json:"rules,omitempty"`
+}
+
+// landlockRule allows the given access rights beneath a path.
+type landlockRule struct {
+	Path   string `json:"path"`
+	Access uint64 `json:"access"`
+}
@@ -0,0 +1,30 @@
+package pie
+
+// apply adds what the shim needs to enforce p to spec, after checking that
+// the kernel supports it.
+func (p *Policy) apply(spec *shimSpec) (err error) {
+	if len(p.Syscalls) > 0 {
+		if spec.Filter, err = seccompFilter(p); err != nil {
+			return err
+		}
+	}
+	if p.Files != nil {
+		spec.Landlock, err = newLandlockRuleset(p.Files)
+	}
+	return err
+}
+
+// restrict enforces the policy described by spec on the plugin executed by
+// this thread.  The seccomp filter comes last, since it may not allow the
+// system calls needed to set up anything else.
+func (spec shimSpec) restrict() error {
+	if spec.Landlock != nil {
+		if err := spec.Landlock.enforce(spec.Path); err != nil {
+			return err
+		}
+	}
+	if len(spec.Filter) > 0 {
+		return installSeccomp(spec.Filter)
+	}
+	return nil
+}
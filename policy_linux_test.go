@@ -0,0 +1,124 @@
+package pie
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Dial connects to the given TCP address and hangs up.
+func (helper) Dial(addr string, _ *int) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// startRestricted starts this test binary as a provider plugin restricted by
+// the given policy, skipping the test if the kernel doesn't support it.
+func startRestricted(t *testing.T, policy Policy) *Plugin {
+	t.Setenv(helperEnv, "1")
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"), WithPolicy(policy))
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("policy not supported: %s", err)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	var pid int
+	if err := p.Call("helper.PID", 0, &pid); err != nil {
+		p.Close()
+		t.Fatalf("Unexpected error from Call: %#v, plugin's stderr: %s", err, p.Stderr())
+	}
+	return p
+}
+
+func TestComputeOnly(t *testing.T) {
+	dir := t.TempDir()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	p := startRestricted(t, ComputeOnly)
+	defer p.Close()
+
+	var names []string
+	if err := p.Call("helper.ReadDir", filepath.Dir(os.Args[0]), &names); err != nil {
+		t.Errorf("Unexpected error reading directory: %#v", err)
+	}
+	if err := p.Call("helper.WriteFile", filepath.Join(dir, "out"), nil); err == nil {
+		t.Error("Expected error writing a file")
+	}
+	if err := p.Call("helper.Dial", l.Addr().String(), nil); err == nil {
+		t.Error("Expected error connecting to the network")
+	}
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	dir := t.TempDir()
+	p := startRestricted(t, ReadOnlyFS)
+	defer p.Close()
+
+	var names []string
+	if err := p.Call("helper.ReadDir", filepath.Dir(os.Args[0]), &names); err != nil {
+		t.Errorf("Unexpected error reading directory: %#v", err)
+	}
+	if err := p.Call("helper.WriteFile", filepath.Join(dir, "out"), nil); err == nil {
+		t.Error("Expected error writing a file")
+	}
+	if err := p.Call("helper.WriteFile", "/dev/null", nil); err != nil {
+		t.Errorf("Unexpected error writing to /dev/null: %#v", err)
+	}
+}
+
+func TestPolicyFiles(t *testing.T) {
+	allowed := t.TempDir()
+	denied := t.TempDir()
+	var libs []string
+	for _, lib := range []string{"/lib", "/lib64", "/usr/lib", "/usr/lib64"} {
+		if _, err := os.Stat(lib); err == nil {
+			libs = append(libs, lib)
+		}
+	}
+	p := startRestricted(t, Policy{Files: &FileAccess{Read: libs, Write: []string{allowed}}})
+	defer p.Close()
+
+	if err := p.Call("helper.WriteFile", filepath.Join(allowed, "out"), nil); err != nil {
+		t.Fatalf("Unexpected error writing to allowed directory: %#v", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(allowed, "out")); err != nil || string(b) != "plugin" {
+		t.Errorf("Expected plugin's file to be written, got %q, %v", b, err)
+	}
+	if err := p.Call("helper.WriteFile", filepath.Join(denied, "out"), nil); err == nil {
+		t.Error("Expected error writing to directory that wasn't allowed")
+	}
+	if err := p.Call("helper.ReadDir", denied, new([]string)); err == nil {
+		t.Error("Expected error reading directory that wasn't allowed")
+	}
+}
+
+func TestPolicyUnknownSyscall(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	_, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"),
+		WithPolicy(Policy{Syscalls: []string{"read", "no_such_call"}}))
+	if err == nil {
+		t.Fatal("Expected error starting plugin with unknown system call in policy")
+	}
+}
+
+func TestProfile(t *testing.T) {
+	if p, err := Profile("compute-only"); err != nil || len(p.Syscalls) == 0 {
+		t.Errorf("Expected compute-only profile to filter system calls, got %#v, %v", p, err)
+	}
+	if p, err := Profile("read-only-fs"); err != nil || p.Files == nil {
+		t.Errorf("Expected read-only-fs profile to limit files, got %#v, %v", p, err)
+	}
+	if _, err := Profile("anything"); err == nil {
+		t.Error("Expected error for unknown profile")
+	}
+}
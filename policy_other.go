@@ -0,0 +1,21 @@
+//go:build !linux
+
+package pie
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errNoPolicy is returned when asked to enforce a Policy on this system.
+var errNoPolicy = fmt.Errorf("%w: policies are only supported on linux", errors.ErrUnsupported)
+
+// apply returns an error, because policies are only supported on Linux.
+func (p *Policy) apply(spec *shimSpec) error {
+	return errNoPolicy
+}
+
+// restrict returns an error, because policies are only supported on Linux.
+func (spec shimSpec) restrict() error {
+	return errNoPolicy
+}
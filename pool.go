@@ -0,0 +1,333 @@
+package pie
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// PoolConfig configures how many instances of its plugin a Pool runs.
+type PoolConfig struct {
+	// Min is how many instances the Pool keeps running.  Instances that exit
+	// are replaced.  It defaults to 1.
+	Min int
+	// Max is how many instances the Pool may run when it is busy.  It
+	// defaults to Min.
+	Max int
+	// QueueDepth is how many calls every instance must have in progress
+	// before the Pool starts another one, up to Max.  It defaults to 1.
+	QueueDepth int
+	// IdleTimeout is how long an instance above Min may go without calls
+	// before the Pool closes it.  It defaults to one minute.
+	IdleTimeout time.Duration
+}
+
+// Pool runs several instances of the same provider plugin and spreads calls
+// across them, sending each call to the instance with the fewest calls in
+// progress.  It keeps at least Min instances running, replacing those that
+// exit, starts more when every instance is busy, and closes the extra ones
+// again once they have been idle for a while.
+//
+// A call is sent to a single instance.  If that instance exits while the call
+// is in progress, the call fails with an error that says why, and isn't
+// retried.
+type Pool struct {
+	start StartFunc
+	cfg   PoolConfig
+
+	closing chan struct{}
+	// wg tracks the goroutines that start and watch instances.
+	wg sync.WaitGroup
+
+	mu      sync.Mutex
+	members []*poolMember
+	// starting is how many instances are being started.
+	starting int
+	// startErr is why the last instance failed to start, if it did.
+	startErr error
+	// changed is closed and replaced whenever members or startErr change.
+	changed chan struct{}
+	closed  bool
+}
+
+// poolMember is an instance of a Pool's plugin.
+type poolMember struct {
+	plugin *Plugin
+	// calls is how many calls the instance has in progress, and idle is
+	// when it last had none.  They are guarded by the Pool's mu.
+	calls int
+	idle  time.Time
+}
+
+// NewPool starts cfg.Min instances of a plugin using start, and returns a Pool
+// that sends calls to them.  It returns an error if any of them can't be
+// started.
+func NewPool(start StartFunc, cfg PoolConfig) (*Pool, error) {
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.QueueDepth <= 0 {
+		cfg.QueueDepth = 1
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = time.Minute
+	}
+	p := &Pool{
+		start:   start,
+		cfg:     cfg,
+		closing: make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	for i := 0; i < cfg.Min; i++ {
+		plugin, err := start()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.add(plugin)
+	}
+	p.wg.Add(1)
+	go p.shrink()
+	return p, nil
+}
+
+// Call invokes the named function on the least busy instance of the plugin,
+// waits for it to complete, and returns its error status.
+func (p *Pool) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	call := <-p.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1)).Done
+	return call.Error
+}
+
+// Go invokes the named function on the least busy instance of the plugin
+// asynchronously, like rpc.Client.Go.  If no instance is running, Go waits
+// for one to be started.  If the last attempt to start one failed, or the Pool
+// is closed, the call fails straight away.
+func (p *Pool) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	return goVia(func() (*Plugin, func(), error) {
+		m, err := p.pick()
+		if err != nil {
+			return nil, nil, err
+		}
+		return m.plugin, func() { p.finish(m) }, nil
+	}, serviceMethod, args, reply, done)
+}
+
+// Size returns how many instances of the plugin are running.
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.members)
+}
+
+// Close shuts down every instance of the plugin.  Calls made after Close fail
+// with ErrClosed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	members := p.members
+	p.members = nil
+	p.notify()
+	p.mu.Unlock()
+
+	close(p.closing)
+	var errs []error
+	for _, m := range members {
+		if err := m.plugin.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// pick returns the running instance with the fewest calls in progress, having
+// counted the call about to be sent to it, and starts another instance if that
+// one is busy.
+func (p *Pool) pick() (*poolMember, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.closed {
+			return nil, ErrClosed
+		}
+		var best *poolMember
+		for _, m := range p.members {
+			select {
+			case <-m.plugin.Done():
+				// watch hasn't removed this instance yet.
+				continue
+			default:
+			}
+			if best == nil || m.calls < best.calls {
+				best = m
+			}
+		}
+		if best != nil {
+			if best.calls >= p.cfg.QueueDepth && len(p.members)+p.starting < p.cfg.Max {
+				p.grow(0)
+			}
+			best.calls++
+			return best, nil
+		}
+		if p.startErr != nil && len(p.members) == 0 {
+			// Don't wait for instances being started again after a
+			// backoff, which may never succeed.
+			return nil, fmt.Errorf("no plugin instances running: %w", p.startErr)
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		<-changed
+		p.mu.Lock()
+	}
+}
+
+// finish records that a call sent to m has completed.
+func (p *Pool) finish(m *poolMember) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if m.calls--; m.calls == 0 {
+		m.idle = time.Now()
+	}
+}
+
+// grow starts another instance of the plugin in the background, after waiting
+// for the given backoff.  If it can't be started and the Pool has fewer than
+// Min instances, it tries again, waiting twice as long, up to 30 seconds.  It
+// must be called with mu held.
+func (p *Pool) grow(backoff time.Duration) {
+	p.starting++
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		select {
+		case <-time.After(backoff):
+		case <-p.closing:
+			p.mu.Lock()
+			p.starting--
+			p.mu.Unlock()
+			return
+		}
+		plugin, err := p.start()
+		if plugin = p.started(plugin, err, backoff); plugin != nil {
+			// The Pool was closed while the instance was starting.
+			plugin.Close()
+		}
+	}()
+}
+
+// started adds an instance that grow has started, or arranges to try again if
+// it couldn't be started.  If the Pool has been closed in the meantime, it
+// returns the instance for the caller to close.
+func (p *Pool) started(plugin *Plugin, err error, backoff time.Duration) *Plugin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.starting--
+	if err != nil {
+		p.startErr = err
+		p.notify()
+		if !p.closed && len(p.members)+p.starting < p.cfg.Min {
+			if backoff = 2 * backoff; backoff < 100*time.Millisecond {
+				backoff = 100 * time.Millisecond
+			} else if backoff > 30*time.Second {
+				backoff = 30 * time.Second
+			}
+			p.grow(backoff)
+		}
+		return nil
+	}
+	if p.closed {
+		return plugin
+	}
+	p.startErr = nil
+	p.addLocked(plugin)
+	return nil
+}
+
+// add adds a running instance of the plugin to the Pool.
+func (p *Pool) add(plugin *Plugin) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addLocked(plugin)
+}
+
+// addLocked is add, for when mu is held.
+func (p *Pool) addLocked(plugin *Plugin) {
+	m := &poolMember{plugin: plugin, idle: time.Now()}
+	p.members = append(p.members, m)
+	p.notify()
+	p.wg.Add(1)
+	go p.watch(m)
+}
+
+// watch replaces m if it exits while it is still one of the Pool's instances
+// and the Pool has fewer than Min of them.
+func (p *Pool) watch(m *poolMember) {
+	defer p.wg.Done()
+	select {
+	case <-m.plugin.Done():
+	case <-p.closing:
+		return
+	}
+	m.plugin.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.remove(m) && !p.closed && len(p.members)+p.starting < p.cfg.Min {
+		p.grow(0)
+	}
+}
+
+// shrink closes instances above Min that have been idle for longer than
+// IdleTimeout, until the Pool is closed.
+func (p *Pool) shrink() {
+	defer p.wg.Done()
+	t := time.NewTicker(p.cfg.IdleTimeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-p.closing:
+			return
+		}
+		p.mu.Lock()
+		var idle []*poolMember
+		for i := len(p.members) - 1; i >= 0 && len(p.members) > p.cfg.Min; i-- {
+			if m := p.members[i]; m.calls == 0 && time.Since(m.idle) >= p.cfg.IdleTimeout {
+				p.remove(m)
+				idle = append(idle, m)
+			}
+		}
+		p.mu.Unlock()
+		for _, m := range idle {
+			m.plugin.Close()
+		}
+	}
+}
+
+// remove removes m from the Pool's instances, and reports whether it was one
+// of them.  It must be called with mu held.
+func (p *Pool) remove(m *poolMember) bool {
+	for i, member := range p.members {
+		if member == m {
+			p.members = append(p.members[:i:i], p.members[i+1:]...)
+			p.notify()
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes up calls waiting for an instance.  It must be called with mu
+// held.
+func (p *Pool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
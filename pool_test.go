@@ -0,0 +1,136 @@
+package pie
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func poolHelper(t *testing.T, cfg PoolConfig) *Pool {
+	p, err := NewPool(func() (*Plugin, error) {
+		return startHelper(t, "provider")
+	}, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewPool: %#v", err)
+	}
+	return p
+}
+
+// busy reports whether the i'th instance of the pool has n calls in progress.
+func (p *Pool) busy(i, n int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return i < len(p.members) && p.members[i].calls == n
+}
+
+func TestPoolLeastOutstanding(t *testing.T) {
+	p := poolHelper(t, PoolConfig{Min: 2})
+	defer p.Close()
+	if size := p.Size(); size != 2 {
+		t.Fatalf("Expected pool to start 2 instances, got %d", size)
+	}
+
+	call := p.Go("helper.Sleep", 300*time.Millisecond, nil, nil)
+	if !eventually(func() bool { return p.busy(0, 1) }) {
+		t.Fatal("Expected first call to be sent to the first instance")
+	}
+	var pid int
+	if err := p.Call("helper.PID", 0, &pid); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if expected := p.members[1].plugin.PID(); pid != expected {
+		t.Errorf("Expected call to be sent to the idle instance %d, got %d", expected, pid)
+	}
+	if c := <-call.Done; c.Error != nil {
+		t.Errorf("Unexpected error from Go: %#v", c.Error)
+	}
+}
+
+func TestPoolGrowAndShrink(t *testing.T) {
+	p := poolHelper(t, PoolConfig{Min: 1, Max: 2, IdleTimeout: 100 * time.Millisecond})
+	defer p.Close()
+
+	call := p.Go("helper.Sleep", 300*time.Millisecond, nil, nil)
+	if !eventually(func() bool { return p.busy(0, 1) }) {
+		t.Fatal("Call was never sent")
+	}
+	// The only instance is busy, so this call makes the pool grow.
+	p.Go("helper.PID", 0, new(int), nil)
+	if !eventually(func() bool { return p.Size() == 2 }) {
+		t.Fatalf("Expected pool to grow to 2 instances, got %d", p.Size())
+	}
+	<-call.Done
+	if !eventually(func() bool { return p.Size() == 1 }) {
+		t.Fatalf("Expected idle pool to shrink to 1 instance, got %d", p.Size())
+	}
+	if err := p.Call("helper.PID", 0, new(int)); err != nil {
+		t.Fatalf("Unexpected error from Call after shrinking: %#v", err)
+	}
+}
+
+func TestPoolReplacesDeadInstances(t *testing.T) {
+	p := poolHelper(t, PoolConfig{Min: 2})
+	defer p.Close()
+
+	dead := p.members[0].plugin
+	if err := p.Call("helper.Exit", 1, nil); err == nil {
+		t.Fatal("Expected error from call that crashed the plugin")
+	}
+	if !eventually(func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.members) == 2 && p.members[0].plugin != dead && p.members[1].plugin != dead
+	}) {
+		t.Fatal("Expected the pool to replace the instance that exited")
+	}
+	if err := p.Call("helper.PID", 0, new(int)); err != nil {
+		t.Fatalf("Unexpected error from Call after replacement: %#v", err)
+	}
+}
+
+func TestPoolRestartFails(t *testing.T) {
+	errStart := errors.New("can't start")
+	starts := 0
+	p, err := NewPool(func() (*Plugin, error) {
+		if starts++; starts > 1 {
+			return nil, errStart
+		}
+		return startHelper(t, "provider")
+	}, PoolConfig{Min: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewPool: %#v", err)
+	}
+	defer p.Close()
+
+	if err := p.Call("helper.Exit", 1, nil); err == nil {
+		t.Fatal("Expected error from call that crashed the plugin")
+	}
+	// With every instance gone and the restart failing, calls fail rather
+	// than waiting for the retry.
+	called := make(chan error, 1)
+	go func() { called <- p.Call("helper.PID", 0, new(int)) }()
+	select {
+	case err := <-called:
+		if !errors.Is(err, errStart) {
+			t.Fatalf("Expected call to fail with the error from starting, got %#v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Call waited for an instance that can't be started")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p := poolHelper(t, PoolConfig{Min: 2})
+	plugins := []*Plugin{p.members[0].plugin, p.members[1].plugin}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	for _, plugin := range plugins {
+		if err := plugin.Err(); err != ErrClosed {
+			t.Errorf("Expected instance to be closed, got %#v", err)
+		}
+	}
+	if err := p.Call("helper.PID", 0, new(int)); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Call after Close, got %#v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package pie
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Spawn starts a process that ignores interrupts and runs until it is
+// killed, and returns its process id once it is ignoring them.
+func (helper) Spawn(_ int, pid *int) error {
+	cmd := exec.Command("sh", "-c", `trap "" INT; echo ready; exec sleep 60`)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if _, err := bufio.NewReader(out).ReadString('\n'); err != nil {
+		return err
+	}
+	*pid = cmd.Process.Pid
+	return nil
+}
+
+// exited reports whether the process with the given id has exited, even if
+// nobody has waited for it yet.
+func exited(pid int) bool {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	// The state follows the command name, which is in parentheses.
+	stat := string(b)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	return len(fields) == 0 || fields[0] == "Z" || fields[0] == "X"
+}
+
+func TestCloseKillsProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to start child processes with")
+	}
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	if pgid, err := syscall.Getpgid(p.PID()); err != nil || pgid != p.PID() {
+		t.Errorf("Expected plugin to lead its own process group, got %d, %v", pgid, err)
+	}
+	var child int
+	if err := p.Call("helper.Spawn", 0, &child); err != nil {
+		p.Close()
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Unexpected error from Close: %#v", err)
+	}
+	if !eventually(func() bool { return exited(child) }) {
+		syscall.Kill(child, syscall.SIGKILL)
+		t.Error("Expected plugin's child process to be killed when the plugin was closed")
+	}
+}
+
+func TestExitKillsProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to start child processes with")
+	}
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	defer p.Close()
+	var child int
+	if err := p.Call("helper.Spawn", 0, &child); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	// The plugin exits on its own, leaving its child behind.
+	p.Call("helper.Exit", 0, nil)
+	<-p.Done()
+	if !eventually(func() bool { return exited(child) }) {
+		syscall.Kill(child, syscall.SIGKILL)
+		t.Error("Expected plugin's child process to be killed when the plugin exited")
+	}
+}
+
+func TestPdeathsig(t *testing.T) {
+	cmd := exec.Command(os.Args[0], helperArgs("provider")...)
+	setProcGroup(execCmd{cmd})
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid || cmd.SysProcAttr.Pdeathsig != syscall.SIGKILL {
+		t.Errorf("Expected plugin to be started in its own group, and killed when the host dies, got %#v", cmd.SysProcAttr)
+	}
+
+	shared := &syscall.SysProcAttr{Setsid: true}
+	cmd = exec.Command(os.Args[0])
+	cmd.SysProcAttr = shared
+	setProcGroup(execCmd{cmd})
+	if cmd.SysProcAttr != shared || shared.Setpgid || shared.Pdeathsig != 0 {
+		t.Errorf("Expected a command's own session to be left alone, got %#v", cmd.SysProcAttr)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package pie
+
+import "syscall"
+
+// setProcGroup does nothing, because there are no process groups on this
+// system.
+func setProcGroup(c execCmd) {}
+
+// inOwnGroup reports false, because there are no process groups on this
+// system.
+func inOwnGroup(attr *syscall.SysProcAttr) bool {
+	return false
+}
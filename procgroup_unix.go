@@ -0,0 +1,70 @@
+//go:build unix
+
+package pie
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// setProcGroup makes c start the plugin application in a process group of its
+// own, unless c already says which process group or session to start it in,
+// so that the plugin and any processes it starts can be stopped together.
+func setProcGroup(c execCmd) {
+	if c.SysProcAttr != nil && (c.SysProcAttr.Setpgid || c.SysProcAttr.Setsid) {
+		return
+	}
+	attr := ownSysProcAttr(c)
+	attr.Setpgid = true
+	setPdeathsig(attr)
+}
+
+// inOwnGroup reports whether a process started with attr leads a process
+// group of its own.
+func inOwnGroup(attr *syscall.SysProcAttr) bool {
+	return attr != nil && (attr.Setsid || attr.Setpgid && attr.Pgid == 0)
+}
+
+// ownSysProcAttr gives c a SysProcAttr of its own to change, copied from the
+// one it has, which may be shared with other commands.
+func ownSysProcAttr(c execCmd) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{}
+	if c.SysProcAttr != nil {
+		*attr = *c.SysProcAttr
+	}
+	c.SysProcAttr = attr
+	return attr
+}
+
+// Signal sends sig to every process in the group.  It returns
+// os.ErrProcessDone if they have all exited.
+func (g procGroup) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return errors.New("unsupported signal type")
+	}
+	if err := syscall.Kill(-g.Pid, s); err != nil {
+		if err == syscall.ESRCH {
+			return os.ErrProcessDone
+		}
+		return os.NewSyscallError("kill", err)
+	}
+	return nil
+}
+
+// Wait waits for the group's leader to exit.  Where the system can tell that
+// the leader has exited without reaping it, anything left running in the group
+// is killed first, since until the leader is reaped, its process group id
+// can't have been reused by another group.
+func (g procGroup) Wait() (*os.ProcessState, error) {
+	if waitExited(g.Pid) {
+		syscall.Kill(-g.Pid, syscall.SIGKILL)
+	}
+	return g.Process.Wait()
+}
+
+// Kill kills every process in the group.
+func (g procGroup) Kill() error {
+	return g.Signal(syscall.SIGKILL)
+}
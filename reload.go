@@ -0,0 +1,194 @@
+package pie
+
+import (
+	"net/rpc"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// reloadDelay is how long a Reloader waits for a plugin's executable to stop
+// changing before starting the new version.  It is adjustable to keep tests
+// fast.
+var reloadDelay = 200 * time.Millisecond
+
+// Reloader runs a provider plugin and starts a new version of it whenever its
+// executable is replaced on disk, such as by rebuilding it with go install, so
+// that the host doesn't have to be restarted to pick up the change.  New calls
+// are sent to the new version once it has started, while calls already in
+// progress are left to finish before the old version is closed.  If the new
+// version can't be started, the old one keeps running.
+//
+// A Reloader only starts the plugin again when its executable changes.  If the
+// plugin exits on its own, calls fail until the executable is next replaced;
+// the exit can be watched for using the Done method of the Plugin returned by
+// Plugin.  Use a Supervisor to restart a plugin that crashes.
+//
+// On Linux, the executable is watched using inotify.  On other systems, it is
+// checked for changes every second.
+type Reloader struct {
+	path  string
+	start StartFunc
+	stop  chan struct{}
+	done  chan struct{}
+	// retiring tracks the old versions that are yet to be closed.
+	retiring sync.WaitGroup
+
+	mu      sync.Mutex
+	current *reloadVersion
+	err     error
+	closed  bool
+}
+
+// reloadVersion is a version of a Reloader's plugin.
+type reloadVersion struct {
+	plugin *Plugin
+	// calls is how many calls are in progress.  retired is set once calls
+	// are sent to a newer version, after which the plugin is closed as soon
+	// as calls is zero.  They are guarded by the Reloader's mu.
+	calls   int
+	retired bool
+}
+
+// NewReloader starts a plugin using start, and starts it again whenever the
+// executable at path changes.  Start should run the executable at path.
+func NewReloader(path string, start StartFunc) (*Reloader, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	changes, err := watchFile(path, stop)
+	if err != nil {
+		return nil, err
+	}
+	p, err := start()
+	if err != nil {
+		close(stop)
+		return nil, err
+	}
+	r := &Reloader{
+		path:    path,
+		start:   start,
+		stop:    stop,
+		done:    make(chan struct{}),
+		current: &reloadVersion{plugin: p},
+	}
+	go r.run(changes)
+	return r, nil
+}
+
+// Call invokes the named function on the current version of the plugin, waits
+// for it to complete, and returns its error status.
+func (r *Reloader) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	call := <-r.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1)).Done
+	return call.Error
+}
+
+// Go invokes the named function on the current version of the plugin
+// asynchronously, like rpc.Client.Go.
+func (r *Reloader) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	return goVia(func() (*Plugin, func(), error) {
+		v, err := r.acquire()
+		if err != nil {
+			return nil, nil, err
+		}
+		return v.plugin, func() { r.release(v) }, nil
+	}, serviceMethod, args, reply, done)
+}
+
+// Plugin returns the current version of the plugin.
+func (r *Reloader) Plugin() *Plugin {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current.plugin
+}
+
+// ReloadErr returns why the last attempt to start a new version of the plugin
+// failed, or nil if it succeeded or there hasn't been one.
+func (r *Reloader) ReloadErr() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close stops watching the plugin's executable and shuts down the plugin
+// application.  Old versions of the plugin that are still finishing calls are
+// waited for.  Calls made after Close fail with ErrClosed.
+func (r *Reloader) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.mu.Unlock()
+	close(r.stop)
+	<-r.done
+	err := r.current.plugin.Close()
+	r.retiring.Wait()
+	return err
+}
+
+// run starts a new version of the plugin once the executable has stopped
+// changing, until the Reloader is closed.
+func (r *Reloader) run(changes <-chan struct{}) {
+	defer close(r.done)
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-changes:
+			settled = time.After(reloadDelay)
+		case <-settled:
+			settled = nil
+			r.reload()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// reload starts a new version of the plugin and sends new calls to it.
+func (r *Reloader) reload() {
+	p, err := r.start()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err = err; err != nil {
+		return
+	}
+	old := r.current
+	r.current = &reloadVersion{plugin: p}
+	old.retired = true
+	r.retiring.Add(1)
+	if old.calls == 0 {
+		go r.retire(old)
+	}
+}
+
+// acquire returns the current version of the plugin, having counted the call
+// about to be sent to it.
+func (r *Reloader) acquire() (*reloadVersion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrClosed
+	}
+	r.current.calls++
+	return r.current, nil
+}
+
+// release records that a call sent to v has completed, and closes v if it has
+// been replaced and this was its last call.
+func (r *Reloader) release(v *reloadVersion) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v.calls--; v.calls == 0 && v.retired {
+		go r.retire(v)
+	}
+}
+
+// retire closes an old version of the plugin.
+func (r *Reloader) retire(v *reloadVersion) {
+	defer r.retiring.Done()
+	v.plugin.Close()
+}
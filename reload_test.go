@@ -0,0 +1,84 @@
+package pie
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// installHelper copies this test binary to path the way go install does, by
+// writing it to a temporary file and renaming it over path.
+func installHelper(t *testing.T, path string) {
+	src, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), "install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmp.Chmod(0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	defer func(d time.Duration) { reloadDelay = d }(reloadDelay)
+	reloadDelay = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "plugin")
+	installHelper(t, path)
+	t.Setenv(helperEnv, "1")
+	r, err := NewReloader(path, func() (*Plugin, error) {
+		return StartProvider(os.Stderr, path, helperArgs("provider")...)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewReloader: %#v", err)
+	}
+	defer r.Close()
+
+	old := r.Plugin()
+	var pid int
+	if err := r.Call("helper.PID", 0, &pid); err != nil || pid != old.PID() {
+		t.Fatalf("Expected call to be sent to plugin %d, got %d, %#v", old.PID(), pid, err)
+	}
+	// A call in progress during the reload is left to finish.
+	call := r.Go("helper.Sleep", 500*time.Millisecond, nil, nil)
+	installHelper(t, path)
+	if !eventually(func() bool { return r.Plugin() != old }) {
+		t.Fatalf("Expected plugin to be reloaded, reload error: %v", r.ReloadErr())
+	}
+	if err := r.Call("helper.PID", 0, &pid); err != nil || pid != r.Plugin().PID() {
+		t.Fatalf("Expected call to be sent to new plugin %d, got %d, %#v", r.Plugin().PID(), pid, err)
+	}
+	if c := <-call.Done; c.Error != nil {
+		t.Errorf("Unexpected error from call in progress during reload: %#v", c.Error)
+	}
+	select {
+	case <-old.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected old plugin to be closed once its call finished")
+	}
+	if err := old.Err(); err != ErrClosed {
+		t.Errorf("Expected old plugin to be closed, got %#v", err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	if err := r.Call("helper.PID", 0, &pid); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Call after Close, got %#v", err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd
+
+package pie
+
+import "syscall"
+
+// Resource limits that the syscall package doesn't define on every system.
+const (
+	rlimitAS    = syscall.RLIMIT_AS
+	rlimitNproc = 7
+)
@@ -0,0 +1,11 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !sparc64
+
+package pie
+
+import "syscall"
+
+// Resource limits that the syscall package doesn't define on every system.
+const (
+	rlimitAS    = syscall.RLIMIT_AS
+	rlimitNproc = 6
+)
@@ -0,0 +1,12 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package pie
+
+import "syscall"
+
+// Resource limits that the syscall package doesn't define on every system.
+// MIPS numbers its limits differently from other Linux architectures.
+const (
+	rlimitAS    = syscall.RLIMIT_AS
+	rlimitNproc = 8
+)
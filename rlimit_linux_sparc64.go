@@ -0,0 +1,12 @@
+//go:build linux && sparc64
+
+package pie
+
+import "syscall"
+
+// Resource limits that the syscall package doesn't define on every system.
+// SPARC numbers its limits differently from other Linux architectures.
+const (
+	rlimitAS    = syscall.RLIMIT_AS
+	rlimitNproc = 7
+)
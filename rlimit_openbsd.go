@@ -0,0 +1,8 @@
+package pie
+
+// Resource limits that the syscall package doesn't define on every system.
+// OpenBSD has no limit on address space.
+const (
+	rlimitAS    = -1
+	rlimitNproc = 7
+)
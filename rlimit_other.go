@@ -0,0 +1,12 @@
+//go:build unix && !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package pie
+
+import "syscall"
+
+// Resource limits that the syscall package doesn't define on every system.
+// This system has no limit on processes.
+const (
+	rlimitAS    = syscall.RLIMIT_AS
+	rlimitNproc = -1
+)
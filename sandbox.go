@@ -0,0 +1,35 @@
+package pie
+
+// Sandbox describes how to isolate an untrusted plugin application from the
+// rest of the system.  The plugin runs in its own user, PID, mount, IPC and
+// UTS namespaces, and unless Network is set, in a network namespace with no
+// network interfaces.  Its filesystem is empty apart from its executable, the
+// paths listed here, a private /tmp, /proc, and a few devices such as
+// /dev/null.  It has no capabilities, even if the host runs as root, and can't
+// gain any.
+//
+// Since the plugin is the first process in its PID namespace, signals it
+// doesn't handle are ignored, and it may need to be killed to stop it.
+type Sandbox struct {
+	// ReadOnly are paths the plugin may read, such as the interpreter a
+	// script is run by and the shared libraries it needs.  They are bind
+	// mounted read-only at the same place in the plugin's filesystem, without
+	// any mounts beneath them.
+	ReadOnly []string
+	// ReadWrite are paths the plugin may read and write.
+	ReadWrite []string
+	// Network leaves the plugin with the host's network.
+	Network bool
+}
+
+// WithSandbox runs the plugin application isolated as described by sb.  Since
+// the host only talks to the plugin over its Stdin and Stdout, or the socket
+// passed by WithSocketTransport, the plugin needs nothing else from the host.
+// The sandbox is set up by the same shim as WithLimits, with the same
+// requirements.
+//
+// WithSandbox is only supported on Linux, and only where unprivileged users
+// may create user namespaces, unless the host is running as root.
+func WithSandbox(sb Sandbox) Option {
+	return func(cfg *config) { cfg.sandbox = &sb }
+}
@@ -0,0 +1,254 @@
+package pie
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// prctl options that the syscall package doesn't define.
+const (
+	prCapbsetDrop        = 24
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+)
+
+// linuxCapabilityVersion3 is the version of the capget and capset structures
+// that holds 64 bits of each capability set.
+const linuxCapabilityVersion3 = 0x20080522
+
+// capHeader and capData are the structures passed to capset.
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// statfs flags that the syscall package doesn't define.
+const (
+	stNosuid     = 0x2
+	stNodev      = 0x4
+	stNoexec     = 0x8
+	stNoatime    = 0x400
+	stNodiratime = 0x800
+	stRelatime   = 0x1000
+)
+
+// devices are the device files bound into a sandbox.
+var devices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom"}
+
+// sandbox makes c start the shim in new namespaces, and returns the empty
+// directory that will become the root of the sandbox.
+func sandbox(c execCmd, sb *Sandbox) (root string, err error) {
+	root, err = os.MkdirTemp("", "pie-sandbox-")
+	if err != nil {
+		return "", fmt.Errorf("can't create sandbox: %w", err)
+	}
+	attr := ownSysProcAttr(c)
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if !sb.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	// The shim runs as root in its user namespace, so that it keeps the
+	// capabilities it needs to set up the sandbox when it is executed.
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+	return root, nil
+}
+
+// enterSandbox builds the sandbox's filesystem under spec.Root, makes it the
+// process' root, and drops the capabilities the shim was given, so that the
+// plugin executed next has none.  The process must already be running in the
+// sandbox's namespaces.
+func (spec *shimSpec) enterSandbox() error {
+	wd, _ := os.Getwd()
+	path, err := filepath.Abs(spec.Path)
+	if err != nil {
+		return err
+	}
+	spec.Path = path
+	root := spec.Root
+
+	// Keep our mounts from propagating back to the host.
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("can't make mounts private: %w", err)
+	}
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("can't mount sandbox root: %w", err)
+	}
+	// /tmp comes first, so that paths under it may be bound into it.
+	if err := mountAt(root, "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return err
+	}
+	if err := bind(root, path, true, false); err != nil {
+		return err
+	}
+	for _, p := range spec.Sandbox.ReadOnly {
+		if err := bind(root, p, true, false); err != nil {
+			return err
+		}
+	}
+	for _, p := range spec.Sandbox.ReadWrite {
+		if err := bind(root, p, false, true); err != nil {
+			return err
+		}
+	}
+	for _, dev := range devices {
+		if err := bind(root, dev, false, false); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := mountAt(root, "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return err
+	}
+
+	old := filepath.Join(root, ".old")
+	if err := os.Mkdir(old, 0700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(root, old); err != nil {
+		return fmt.Errorf("can't change root to sandbox: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.old", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("can't detach host filesystem: %w", err)
+	}
+	if err := os.Remove("/.old"); err != nil {
+		return err
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("can't make sandbox root read-only: %w", err)
+	}
+	// Stay in the same working directory if it's in the sandbox.
+	if wd != "" {
+		os.Chdir(wd)
+	}
+	return dropPrivileges()
+}
+
+// mountAt mounts a filesystem of the given type at dir within root.
+func mountAt(root, dir, fstype string, flags uintptr, data string) error {
+	target := filepath.Join(root, dir)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(fstype, target, fstype, flags, data); err != nil {
+		return fmt.Errorf("can't mount %s in sandbox: %w", dir, err)
+	}
+	return nil
+}
+
+// bind bind mounts path at the same place within root.  A read-only mount
+// only includes path's own mount, and not those beneath it.
+func bind(root, path string, readOnly, recursive bool) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("can't bind %s in sandbox: %w", path, err)
+	}
+	target := filepath.Join(root, path)
+	if fi.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND)
+	if recursive {
+		flags |= syscall.MS_REC
+	}
+	if err := syscall.Mount(path, target, "", flags, ""); err != nil {
+		return fmt.Errorf("can't bind %s in sandbox: %w", path, err)
+	}
+	if !readOnly {
+		return nil
+	}
+	// Remounting must keep the flags the mount already has, which can't be
+	// cleared from within a user namespace.
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(target, &st); err != nil {
+		return err
+	}
+	flags = syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | mountFlags(int64(st.Flags))
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("can't make %s read-only in sandbox: %w", path, err)
+	}
+	return nil
+}
+
+// mountFlags returns the mount flags that match the given statfs flags.
+func mountFlags(st int64) uintptr {
+	var flags uintptr
+	for stFlag, msFlag := range map[int64]uintptr{
+		stNosuid:     syscall.MS_NOSUID,
+		stNodev:      syscall.MS_NODEV,
+		stNoexec:     syscall.MS_NOEXEC,
+		stNoatime:    syscall.MS_NOATIME,
+		stNodiratime: syscall.MS_NODIRATIME,
+		stRelatime:   syscall.MS_RELATIME,
+	} {
+		if st&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+	return flags
+}
+
+// dropPrivileges makes sure that the plugin executed by this thread has no
+// capabilities, and can't gain any.  Capabilities are removed from the bounding
+// set first, since that needs CAP_SETPCAP, and then every other set is
+// cleared, so that the plugin doesn't keep them even if it runs as root.
+func dropPrivileges() error {
+	for c := uintptr(0); ; c++ {
+		_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, c, 0)
+		if errno == syscall.EINVAL {
+			// We've run out of capabilities.
+			break
+		}
+		if errno != 0 {
+			return fmt.Errorf("can't drop capabilities: %w", errno)
+		}
+	}
+	// Kernels before 4.3 have no ambient capabilities to clear.
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+	if errno != 0 && errno != syscall.EINVAL {
+		return fmt.Errorf("can't clear ambient capabilities: %w", errno)
+	}
+	hdr := capHeader{version: linuxCapabilityVersion3}
+	var data [2]capData
+	_, _, errno = syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return fmt.Errorf("can't clear capabilities: %w", errno)
+	}
+	return noNewPrivs()
+}
+
+// noNewPrivs stops the plugin executed by this thread from gaining privileges
+// through setuid executables or file capabilities.
+func noNewPrivs() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("can't set no_new_privs: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package pie
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// ReadDir returns the names of the files in a directory in the plugin
+// application's filesystem.
+func (helper) ReadDir(dir string, names *[]string) error {
+	entries, err := ioutil.ReadDir(dir)
+	for _, entry := range entries {
+		*names = append(*names, entry.Name())
+	}
+	return err
+}
+
+// WriteFile writes a file in the plugin application's filesystem.
+func (helper) WriteFile(path string, _ *int) error {
+	return ioutil.WriteFile(path, []byte("plugin"), 0644)
+}
+
+// Interfaces returns the names of the plugin application's network
+// interfaces.
+func (helper) Interfaces(_ int, names *[]string) error {
+	ifaces, err := net.Interfaces()
+	for _, iface := range ifaces {
+		*names = append(*names, iface.Name)
+	}
+	return err
+}
+
+// Capabilities returns the plugin application's capability sets, as shown in
+// /proc/self/status, by name.
+func (helper) Capabilities(_ int, caps *map[string]string) error {
+	b, err := ioutil.ReadFile("/proc/self/status")
+	*caps = map[string]string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.HasPrefix(name, "Cap") {
+			(*caps)[name] = strings.TrimSpace(value)
+		}
+	}
+	return err
+}
+
+// startSandboxed starts this test binary as a provider plugin in a sandbox
+// that can run it, with the given paths readable, and any other options.
+func startSandboxed(t *testing.T, sb Sandbox, opts ...Option) *Plugin {
+	for _, lib := range []string{"/lib", "/lib64", "/usr/lib", "/usr/lib64"} {
+		if _, err := os.Stat(lib); err == nil {
+			sb.ReadOnly = append(sb.ReadOnly, lib)
+		}
+	}
+	t.Setenv(helperEnv, "1")
+	opts = append(opts, WithSandbox(sb))
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"), opts...)
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	var pid int
+	if err := p.Call("helper.PID", 0, &pid); err != nil {
+		p.Close()
+		if p.Stderr() != "" {
+			t.Skipf("can't run sandboxed plugin: %s", p.Stderr())
+		}
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if pid != 1 {
+		t.Errorf("Expected plugin to be process 1 in its PID namespace, got %d", pid)
+	}
+	return p
+}
+
+func TestSandbox(t *testing.T) {
+	shared := t.TempDir()
+	hidden := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(shared, "data"), []byte("host"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := startSandboxed(t, Sandbox{ReadOnly: []string{shared}})
+	defer p.Close()
+
+	var names []string
+	if err := p.Call("helper.ReadDir", shared, &names); err != nil {
+		t.Fatalf("Unexpected error reading shared directory: %#v", err)
+	}
+	if len(names) != 1 || names[0] != "data" {
+		t.Errorf("Expected shared directory to contain data, got %v", names)
+	}
+	if err := p.Call("helper.WriteFile", filepath.Join(shared, "new"), nil); err == nil {
+		t.Error("Expected error writing to read-only directory")
+	}
+	if err := p.Call("helper.ReadDir", hidden, new([]string)); err == nil {
+		t.Error("Expected directory that wasn't shared to be missing")
+	}
+	if err := p.Call("helper.ReadDir", "/etc", new([]string)); err == nil {
+		t.Error("Expected /etc to be missing")
+	}
+	if err := p.Call("helper.WriteFile", "/tmp/scratch", nil); err != nil {
+		t.Errorf("Unexpected error writing to /tmp: %#v", err)
+	}
+	if _, err := os.Stat("/tmp/scratch"); err == nil {
+		t.Error("Expected plugin's /tmp to be private")
+	}
+	var ifaces []string
+	if err := p.Call("helper.Interfaces", 0, &ifaces); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if len(ifaces) != 1 || ifaces[0] != "lo" {
+		t.Errorf("Expected only a loopback interface, got %v", ifaces)
+	}
+}
+
+func TestSandboxCapabilities(t *testing.T) {
+	// Even if the sandbox is started with capabilities that would survive
+	// executing the plugin, the plugin has none.
+	const capNetRaw = 13
+	p := startSandboxed(t, Sandbox{}, WithSysProcAttr(&syscall.SysProcAttr{
+		AmbientCaps: []uintptr{capNetRaw},
+	}))
+	defer p.Close()
+
+	var caps map[string]string
+	if err := p.Call("helper.Capabilities", 0, &caps); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if _, ok := caps["CapEff"]; !ok {
+		t.Fatalf("Expected CapEff in /proc/self/status, got %v", caps)
+	}
+	for _, name := range []string{"CapInh", "CapPrm", "CapEff", "CapBnd", "CapAmb"} {
+		if value, ok := caps[name]; ok && strings.Trim(value, "0") != "" {
+			t.Errorf("Expected plugin to have no %s capabilities, got %s", name, value)
+		}
+	}
+}
+
+func TestSandboxReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	p := startSandboxed(t, Sandbox{ReadWrite: []string{dir}, Network: true})
+	defer p.Close()
+
+	if err := p.Call("helper.WriteFile", filepath.Join(dir, "out"), nil); err != nil {
+		t.Fatalf("Unexpected error writing to read-write directory: %#v", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "out")); err != nil || string(b) != "plugin" {
+		t.Errorf("Expected plugin's file to be visible to the host, got %q, %v", b, err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package pie
+
+import "errors"
+
+// errNoSandbox is returned when asked for a sandbox on this system.
+var errNoSandbox = errors.New("sandboxing is only supported on linux")
+
+// sandbox returns an error, because sandboxing is only supported on Linux.
+func sandbox(c execCmd, sb *Sandbox) (string, error) {
+	return "", errNoSandbox
+}
+
+// enterSandbox returns an error, because sandboxing is only supported on
+// Linux.
+func (spec *shimSpec) enterSandbox() error {
+	return errNoSandbox
+}
@@ -0,0 +1,140 @@
+package pie
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// seccomp constants that the syscall package doesn't define.
+const (
+	prSetSeccomp          = 22
+	seccompModeFilter     = 2
+	seccompGetActionAvail = 2
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+)
+
+// Offsets of the fields of struct seccomp_data that filters look at.  Both
+// supported architectures are little endian, so the low half of an argument
+// comes first.
+const (
+	seccompNr   = 0
+	seccompArch = 4
+	seccompArgs = 16
+)
+
+// The classic BPF instructions used by seccomp filters.
+const (
+	bpfLoad = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfAnd  = 0x54 // BPF_ALU | BPF_AND | BPF_K
+	bpfJeq  = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfRet  = 0x06 // BPF_RET | BPF_K
+)
+
+// openFlags are the flags that open and openat may not be given when
+// Policy.OpenReadOnly allows them.
+const openFlags = syscall.O_ACCMODE | syscall.O_CREAT | syscall.O_TRUNC
+
+// seccompFilter returns a seccomp filter that only allows the system calls
+// permitted by p.  A system call made using another architecture's calling
+// convention kills the process, since the filter can't tell what it is.
+func seccompFilter(p *Policy) ([]bpfInstruction, error) {
+	if auditArch == 0 {
+		return nil, fmt.Errorf("%w: seccomp filters aren't supported on %s", errors.ErrUnsupported, runtime.GOARCH)
+	}
+	allowed := map[int]bool{syscallNumbers["execve"]: true}
+	for _, name := range p.Syscalls {
+		nr, ok := syscallNumbers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown system call %q in policy", name)
+		}
+		if nr >= 0 {
+			allowed[nr] = true
+		}
+	}
+	if err := seccompSupported(); err != nil {
+		return nil, err
+	}
+	nrs := make([]int, 0, len(allowed))
+	for nr := range allowed {
+		nrs = append(nrs, nr)
+	}
+	sort.Ints(nrs)
+
+	prog := []bpfInstruction{
+		{Code: bpfLoad, K: seccompArch},
+		{Code: bpfJeq, Jt: 1, K: auditArch},
+		{Code: bpfRet, K: seccompRetKillProcess},
+		{Code: bpfLoad, K: seccompNr},
+	}
+	for _, nr := range nrs {
+		prog = append(prog,
+			bpfInstruction{Code: bpfJeq, Jf: 1, K: uint32(nr)},
+			bpfInstruction{Code: bpfRet, K: seccompRetAllow},
+		)
+	}
+	// allowIf allows the named system call if the given argument, masked,
+	// has the given value.
+	allowIf := func(name string, arg int, mask, value uint32) {
+		nr := syscallNumbers[name]
+		if nr < 0 || allowed[nr] {
+			return
+		}
+		prog = append(prog,
+			bpfInstruction{Code: bpfJeq, Jf: 5, K: uint32(nr)},
+			bpfInstruction{Code: bpfLoad, K: uint32(seccompArgs + 8*arg)},
+			bpfInstruction{Code: bpfAnd, K: mask},
+			bpfInstruction{Code: bpfJeq, Jf: 1, K: value},
+			bpfInstruction{Code: bpfRet, K: seccompRetAllow},
+			bpfInstruction{Code: bpfRet, K: seccompRetErrno | uint32(syscall.EPERM)},
+		)
+	}
+	if p.OpenReadOnly {
+		allowIf("open", 1, openFlags, syscall.O_RDONLY)
+		allowIf("openat", 2, openFlags, syscall.O_RDONLY)
+	}
+	if p.ThreadsOnly {
+		allowIf("clone", 0, syscall.CLONE_THREAD, syscall.CLONE_THREAD)
+	}
+	if nr := syscallNumbers["clone3"]; !allowed[nr] {
+		prog = append(prog,
+			bpfInstruction{Code: bpfJeq, Jf: 1, K: uint32(nr)},
+			bpfInstruction{Code: bpfRet, K: seccompRetErrno | uint32(syscall.ENOSYS)},
+		)
+	}
+	return append(prog, bpfInstruction{Code: bpfRet, K: seccompRetErrno | uint32(syscall.EPERM)}), nil
+}
+
+// seccompSupported returns an error if the kernel can't run the filters made
+// by seccompFilter.
+func seccompSupported() error {
+	action := uint32(seccompRetKillProcess)
+	_, _, errno := syscall.Syscall(uintptr(syscallNumbers["seccomp"]), seccompGetActionAvail, 0, uintptr(unsafe.Pointer(&action)))
+	if errno != 0 {
+		return fmt.Errorf("%w: kernel doesn't support seccomp filters: %w", errors.ErrUnsupported, errno)
+	}
+	return nil
+}
+
+// installSeccomp makes the plugin executed by this thread run with the given
+// seccomp filter.
+func installSeccomp(prog []bpfInstruction) error {
+	if err := noNewPrivs(); err != nil {
+		return err
+	}
+	fprog := struct {
+		len    uint16
+		filter *bpfInstruction
+	}{uint16(len(prog)), &prog[0]}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		return fmt.Errorf("can't install seccomp filter: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package pie
+
+// auditArch is the architecture seccomp filters expect, AUDIT_ARCH_X86_64.
+const auditArch = 0xc000003e
+
+// syscallNumbers are the numbers of the system calls that a Policy may name.
+// Those that don't exist on this architecture are -1.
+var syscallNumbers = map[string]int{
+	"read":                    0,
+	"write":                   1,
+	"readv":                   19,
+	"writev":                  20,
+	"pread64":                 17,
+	"pwrite64":                18,
+	"open":                    2,
+	"openat":                  257,
+	"close":                   3,
+	"fstat":                   5,
+	"stat":                    4,
+	"lstat":                   6,
+	"newfstatat":              262,
+	"statx":                   332,
+	"lseek":                   8,
+	"access":                  21,
+	"faccessat":               269,
+	"faccessat2":              439,
+	"readlink":                89,
+	"readlinkat":              267,
+	"getdents64":              217,
+	"getcwd":                  79,
+	"chdir":                   80,
+	"fchdir":                  81,
+	"ioctl":                   16,
+	"fcntl":                   72,
+	"dup":                     32,
+	"dup2":                    33,
+	"dup3":                    292,
+	"pipe":                    22,
+	"pipe2":                   293,
+	"eventfd2":                290,
+	"poll":                    7,
+	"ppoll":                   271,
+	"select":                  23,
+	"pselect6":                270,
+	"epoll_create":            213,
+	"epoll_create1":           291,
+	"epoll_ctl":               233,
+	"epoll_wait":              232,
+	"epoll_pwait":             281,
+	"mmap":                    9,
+	"munmap":                  11,
+	"mprotect":                10,
+	"mremap":                  25,
+	"madvise":                 28,
+	"brk":                     12,
+	"rt_sigaction":            13,
+	"rt_sigprocmask":          14,
+	"rt_sigreturn":            15,
+	"rt_sigtimedwait":         128,
+	"sigaltstack":             131,
+	"futex":                   202,
+	"sched_yield":             24,
+	"sched_getaffinity":       204,
+	"nanosleep":               35,
+	"clock_gettime":           228,
+	"clock_getres":            229,
+	"clock_nanosleep":         230,
+	"gettimeofday":            96,
+	"getpid":                  39,
+	"gettid":                  186,
+	"getppid":                 110,
+	"getuid":                  102,
+	"geteuid":                 107,
+	"getgid":                  104,
+	"getegid":                 108,
+	"tkill":                   200,
+	"tgkill":                  234,
+	"kill":                    62,
+	"exit":                    60,
+	"exit_group":              231,
+	"getrandom":               318,
+	"uname":                   63,
+	"getrlimit":               97,
+	"setrlimit":               160,
+	"prlimit64":               302,
+	"getrusage":               98,
+	"sysinfo":                 99,
+	"arch_prctl":              158,
+	"set_tid_address":         218,
+	"set_robust_list":         273,
+	"rseq":                    334,
+	"restart_syscall":         219,
+	"clone":                   56,
+	"clone3":                  435,
+	"fork":                    57,
+	"vfork":                   58,
+	"execve":                  59,
+	"execveat":                322,
+	"wait4":                   61,
+	"waitid":                  247,
+	"socket":                  41,
+	"socketpair":              53,
+	"connect":                 42,
+	"accept":                  43,
+	"accept4":                 288,
+	"bind":                    49,
+	"listen":                  50,
+	"sendto":                  44,
+	"recvfrom":                45,
+	"sendmsg":                 46,
+	"recvmsg":                 47,
+	"shutdown":                48,
+	"getsockname":             51,
+	"getpeername":             52,
+	"setsockopt":              54,
+	"getsockopt":              55,
+	"mkdir":                   83,
+	"mkdirat":                 258,
+	"rmdir":                   84,
+	"unlink":                  87,
+	"unlinkat":                263,
+	"rename":                  82,
+	"renameat":                264,
+	"renameat2":               316,
+	"link":                    86,
+	"linkat":                  265,
+	"symlink":                 88,
+	"symlinkat":               266,
+	"creat":                   85,
+	"truncate":                76,
+	"ftruncate":               77,
+	"chmod":                   90,
+	"fchmod":                  91,
+	"fchmodat":                268,
+	"chown":                   92,
+	"fchown":                  93,
+	"fchownat":                260,
+	"mknod":                   133,
+	"mknodat":                 259,
+	"utimensat":               280,
+	"fsync":                   74,
+	"fdatasync":               75,
+	"flock":                   73,
+	"ptrace":                  101,
+	"mount":                   165,
+	"umount2":                 166,
+	"prctl":                   157,
+	"setns":                   308,
+	"unshare":                 272,
+	"memfd_create":            319,
+	"epoll_pwait2":            441,
+	"close_range":             436,
+	"membarrier":              324,
+	"sendfile":                40,
+	"copy_file_range":         326,
+	"seccomp":                 317,
+	"bpf":                     321,
+	"setpgid":                 109,
+	"setsid":                  112,
+	"umask":                   95,
+	"landlock_create_ruleset": 444,
+	"landlock_add_rule":       445,
+	"landlock_restrict_self":  446,
+}
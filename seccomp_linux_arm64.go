@@ -0,0 +1,164 @@
+package pie
+
+// auditArch is the architecture seccomp filters expect, AUDIT_ARCH_AARCH64.
+const auditArch = 0xc00000b7
+
+// syscallNumbers are the numbers of the system calls that a Policy may name.
+// Those that don't exist on this architecture are -1.
+var syscallNumbers = map[string]int{
+	"read":                    63,
+	"write":                   64,
+	"readv":                   65,
+	"writev":                  66,
+	"pread64":                 67,
+	"pwrite64":                68,
+	"open":                    -1,
+	"openat":                  56,
+	"close":                   57,
+	"fstat":                   80,
+	"stat":                    -1,
+	"lstat":                   -1,
+	"newfstatat":              79,
+	"statx":                   291,
+	"lseek":                   62,
+	"access":                  -1,
+	"faccessat":               48,
+	"faccessat2":              439,
+	"readlink":                -1,
+	"readlinkat":              78,
+	"getdents64":              61,
+	"getcwd":                  17,
+	"chdir":                   49,
+	"fchdir":                  50,
+	"ioctl":                   29,
+	"fcntl":                   25,
+	"dup":                     23,
+	"dup2":                    -1,
+	"dup3":                    24,
+	"pipe":                    -1,
+	"pipe2":                   59,
+	"eventfd2":                19,
+	"poll":                    -1,
+	"ppoll":                   73,
+	"select":                  -1,
+	"pselect6":                72,
+	"epoll_create":            -1,
+	"epoll_create1":           20,
+	"epoll_ctl":               21,
+	"epoll_wait":              -1,
+	"epoll_pwait":             22,
+	"mmap":                    222,
+	"munmap":                  215,
+	"mprotect":                226,
+	"mremap":                  216,
+	"madvise":                 233,
+	"brk":                     214,
+	"rt_sigaction":            134,
+	"rt_sigprocmask":          135,
+	"rt_sigreturn":            139,
+	"rt_sigtimedwait":         137,
+	"sigaltstack":             132,
+	"futex":                   98,
+	"sched_yield":             124,
+	"sched_getaffinity":       123,
+	"nanosleep":               101,
+	"clock_gettime":           113,
+	"clock_getres":            114,
+	"clock_nanosleep":         115,
+	"gettimeofday":            169,
+	"getpid":                  172,
+	"gettid":                  178,
+	"getppid":                 173,
+	"getuid":                  174,
+	"geteuid":                 175,
+	"getgid":                  176,
+	"getegid":                 177,
+	"tkill":                   130,
+	"tgkill":                  131,
+	"kill":                    129,
+	"exit":                    93,
+	"exit_group":              94,
+	"getrandom":               278,
+	"uname":                   160,
+	"getrlimit":               163,
+	"setrlimit":               164,
+	"prlimit64":               261,
+	"getrusage":               165,
+	"sysinfo":                 179,
+	"arch_prctl":              -1,
+	"set_tid_address":         96,
+	"set_robust_list":         99,
+	"rseq":                    293,
+	"restart_syscall":         128,
+	"clone":                   220,
+	"clone3":                  435,
+	"fork":                    -1,
+	"vfork":                   -1,
+	"execve":                  221,
+	"execveat":                281,
+	"wait4":                   260,
+	"waitid":                  95,
+	"socket":                  198,
+	"socketpair":              199,
+	"connect":                 203,
+	"accept":                  202,
+	"accept4":                 242,
+	"bind":                    200,
+	"listen":                  201,
+	"sendto":                  206,
+	"recvfrom":                207,
+	"sendmsg":                 211,
+	"recvmsg":                 212,
+	"shutdown":                210,
+	"getsockname":             204,
+	"getpeername":             205,
+	"setsockopt":              208,
+	"getsockopt":              209,
+	"mkdir":                   -1,
+	"mkdirat":                 34,
+	"rmdir":                   -1,
+	"unlink":                  -1,
+	"unlinkat":                35,
+	"rename":                  -1,
+	"renameat":                38,
+	"renameat2":               276,
+	"link":                    -1,
+	"linkat":                  37,
+	"symlink":                 -1,
+	"symlinkat":               36,
+	"creat":                   -1,
+	"truncate":                45,
+	"ftruncate":               46,
+	"chmod":                   -1,
+	"fchmod":                  52,
+	"fchmodat":                53,
+	"chown":                   -1,
+	"fchown":                  55,
+	"fchownat":                54,
+	"mknod":                   -1,
+	"mknodat":                 33,
+	"utimensat":               88,
+	"fsync":                   82,
+	"fdatasync":               83,
+	"flock":                   32,
+	"ptrace":                  117,
+	"mount":                   40,
+	"umount2":                 39,
+	"prctl":                   167,
+	"setns":                   268,
+	"unshare":                 97,
+	"memfd_create":            279,
+	"epoll_pwait2":            441,
+	"close_range":             436,
+	"membarrier":              283,
+	"sendfile":                71,
+	"copy_file_range":         285,
+	"seccomp":                 277,
+	"bpf":                     280,
+	"setpgid":                 154,
+	"setsid":                  157,
+	"umask":                   166,
+	"landlock_create_ruleset": 444,
+	"landlock_add_rule":       445,
+	"landlock_restrict_self":  446,
+}
@@ -0,0 +1,11 @@
+//go:build linux && !amd64 && !arm64
+
+package pie
+
+// auditArch is zero, because seccomp filters aren't supported on this
+// architecture.
+const auditArch = 0
+
+// syscallNumbers is empty, because seccomp filters aren't supported on this
+// architecture.
+var syscallNumbers map[string]int
@@ -0,0 +1,11 @@
+//go:build dragonfly || freebsd
+
+package pie
+
+import "syscall"
+
+// setrlimit sets both the soft and hard limits of resource to value.  These
+// systems use signed limits.
+func setrlimit(resource int, value uint64) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: int64(value), Max: int64(value)})
+}
@@ -0,0 +1,10 @@
+//go:build unix && !dragonfly && !freebsd
+
+package pie
+
+import "syscall"
+
+// setrlimit sets both the soft and hard limits of resource to value.
+func setrlimit(resource int, value uint64) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value})
+}
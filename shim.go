@@ -0,0 +1,104 @@
+package pie
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// shimEnv is the environment variable that tells this package's init function
+// that the process was started as a shim for a plugin application, and how to
+// set up the plugin's process before executing it.
+const shimEnv = "PIE_SHIM"
+
+// shimFailed is the exit code of a shim that couldn't set up or execute the
+// plugin application.
+const shimFailed = 126
+
+// shimSpec is what the shim does before executing the plugin application.
+type shimSpec struct {
+	// Path is the plugin's executable.  The shim's arguments are passed on to
+	// the plugin.
+	Path    string       `json:"path"`
+	Rlimits []shimRlimit `json:"rlimits,omitempty"`
+	// Sandbox is the sandbox to run the plugin in, and Root is the empty
+	// directory to build its filesystem in.
+	Sandbox *Sandbox `json:"sandbox,omitempty"`
+	Root    string   `json:"root,omitempty"`
+	// Filter is the seccomp filter and Landlock the Landlock ruleset that
+	// enforce the plugin's Policy.
+	Filter   []bpfInstruction `json:"filter,omitempty"`
+	Landlock *landlockRuleset `json:"landlock,omitempty"`
+}
+
+// shimRlimit is a resource limit to set, with both its soft and hard limits
+// set to Value.
+type shimRlimit struct {
+	Resource int    `json:"resource"`
+	Value    uint64 `json:"value"`
+}
+
+func init() {
+	if spec := os.Getenv(shimEnv); spec != "" {
+		runShim(spec)
+	}
+}
+
+// runShim sets up the process as described by the JSON encoded spec and
+// executes the plugin application in it.  It never returns.
+func runShim(encoded string) {
+	// Some of the process' attributes belong to the thread that sets them,
+	// so the plugin must be executed by the same thread.
+	runtime.LockOSThread()
+	os.Unsetenv(shimEnv)
+	var spec shimSpec
+	err := json.Unmarshal([]byte(encoded), &spec)
+	if err == nil {
+		err = spec.exec()
+	}
+	fmt.Fprintf(os.Stderr, "pie: can't start plugin: %s\n", err)
+	os.Exit(shimFailed)
+}
+
+// shim makes c start the host's executable as a shim that sets up the process
+// according to cfg before executing the plugin application, if cfg asks for
+// anything that needs a shim.  It returns a function, if any is needed, that
+// cleans up after the plugin once it has exited, or failed to start.
+func (cfg *config) shim(c execCmd) (cleanup func(), err error) {
+	spec := shimSpec{Path: c.Path, Sandbox: cfg.sandbox}
+	if spec.Rlimits, err = cfg.limits.rlimits(); err != nil {
+		return nil, err
+	}
+	if cfg.policy != nil {
+		if err := cfg.policy.apply(&spec); err != nil {
+			return nil, err
+		}
+	}
+	if len(spec.Rlimits) == 0 && spec.Sandbox == nil && spec.Filter == nil && spec.Landlock == nil {
+		return nil, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("can't find host executable to use as shim: %w", err)
+	}
+	if spec.Sandbox != nil {
+		if spec.Root, err = sandbox(c, spec.Sandbox); err != nil {
+			return nil, err
+		}
+		// The sandbox's filesystem only exists in the plugin's mount
+		// namespace, so the directory is empty again once the plugin is
+		// gone.
+		cleanup = func() { os.Remove(spec.Root) }
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	c.Path = exe
+	if c.Env == nil {
+		c.Env = os.Environ()
+	}
+	c.Env = append(c.Env, shimEnv+"="+string(b))
+	return cleanup, nil
+}
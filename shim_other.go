@@ -0,0 +1,19 @@
+//go:build !unix
+
+package pie
+
+import "errors"
+
+// rlimits returns an error if any limits are set, because resource limits
+// aren't supported on this system.
+func (l Limits) rlimits() ([]shimRlimit, error) {
+	if l != (Limits{}) {
+		return nil, errors.New("resource limits are only supported on unix systems")
+	}
+	return nil, nil
+}
+
+// exec always fails, because a shim is never started on this system.
+func (spec shimSpec) exec() error {
+	return errors.New("shim is not supported on this system")
+}
@@ -0,0 +1,51 @@
+//go:build unix
+
+package pie
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// rlimits returns the resource limits to set for l.
+func (l Limits) rlimits() ([]shimRlimit, error) {
+	var rlimits []shimRlimit
+	add := func(resource int, value uint64) {
+		if value > 0 {
+			rlimits = append(rlimits, shimRlimit{Resource: resource, Value: value})
+		}
+	}
+	if l.AddressSpace > 0 && rlimitAS < 0 {
+		return nil, errors.New("can't limit address space on this system")
+	}
+	if l.Processes > 0 && rlimitNproc < 0 {
+		return nil, errors.New("can't limit processes on this system")
+	}
+	add(rlimitAS, l.AddressSpace)
+	add(syscall.RLIMIT_CPU, uint64((l.CPUTime+time.Second-1)/time.Second))
+	add(syscall.RLIMIT_NOFILE, l.OpenFiles)
+	add(rlimitNproc, l.Processes)
+	return rlimits, nil
+}
+
+// exec sets up the process as described by spec, and executes the plugin.
+// It only returns if that fails.
+func (spec shimSpec) exec() error {
+	if spec.Sandbox != nil {
+		if err := spec.enterSandbox(); err != nil {
+			return err
+		}
+	}
+	for _, r := range spec.Rlimits {
+		if err := setrlimit(r.Resource, r.Value); err != nil {
+			return fmt.Errorf("can't set resource limit %d: %w", r.Resource, err)
+		}
+	}
+	if err := spec.restrict(); err != nil {
+		return err
+	}
+	return syscall.Exec(spec.Path, os.Args, os.Environ())
+}
@@ -0,0 +1,268 @@
+package pie
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// graceEnv tells a plugin application how long its host waits for it to exit
+// after the first stop signal, before killing it.
+const graceEnv = "PIE_STOP_GRACE"
+
+// defaultGrace is how long a plugin application assumes its host waits for it
+// to exit, if the host doesn't say.  It matches the host's default stop
+// timeout.
+const defaultGrace = time.Second
+
+var (
+	graceOnce sync.Once
+	grace     time.Duration
+)
+
+// hostGrace returns how long this plugin application has to shut down once
+// it is asked to stop, less a tenth, so that it has time to exit before its
+// host kills it.
+func hostGrace() time.Duration {
+	graceOnce.Do(func() {
+		grace = defaultGrace
+		if s := os.Getenv(graceEnv); s != "" {
+			os.Unsetenv(graceEnv)
+			if ms, err := strconv.ParseInt(s, 10, 64); err == nil && ms > 0 {
+				grace = time.Duration(ms) * time.Millisecond
+			}
+		}
+		grace -= grace / 10
+	})
+	return grace
+}
+
+// graceFor returns how long the host waits for a plugin stopped using steps
+// to exit before killing it, in the form passed in graceEnv.
+func graceFor(steps []StopStep) string {
+	if len(steps) == 0 {
+		steps = []StopStep{{Wait: procTimeout}}
+	}
+	var total time.Duration
+	for _, step := range steps {
+		total += step.Wait
+	}
+	return strconv.FormatInt(total.Milliseconds(), 10)
+}
+
+// Shutdown shuts the Server down gracefully: it stops accepting new requests,
+// waits for the methods already running to return and their replies to be
+// written, and then closes the connection, which makes Serve and ServeCodec
+// return.  If ctx is done first, the connection is closed anyway and Shutdown
+// returns ctx.Err().
+//
+// A Server returned by NewProvider shuts itself down when the plugin
+// application is sent os.Interrupt while it is serving, as it is when the host
+// closes the Plugin, unless the plugin ignores interrupts.  It is given as long
+// as the host waits for it to exit before killing it.
+func (s Server) Shutdown(ctx context.Context) error {
+	st := s.state
+	if st == nil {
+		return s.Close()
+	}
+	var err error
+	select {
+	case <-st.drain():
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	st.closeOnce.Do(func() {
+		st.closeErr = s.Close()
+		close(st.closed)
+	})
+	if err == nil {
+		err = st.closeErr
+	}
+	return err
+}
+
+// serverState is shared by every copy of a Server, to keep track of the calls
+// in flight so that they can be waited for when the Server is shut down.
+type serverState struct {
+	// signals is set for Servers that shut down when the plugin application
+	// is interrupted.
+	signals bool
+	// closed is closed once Shutdown has closed the connection.
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	mu      sync.Mutex
+	calls   int
+	closing bool
+	// idle is closed when the last call in flight returns, once the Server
+	// is closing.
+	idle chan struct{}
+}
+
+// newServerState returns the state of a new Server.
+func newServerState() *serverState {
+	return &serverState{closed: make(chan struct{})}
+}
+
+// start records that a call has been received, and reports whether it may be
+// served.
+func (st *serverState) start() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closing {
+		return false
+	}
+	st.calls++
+	return true
+}
+
+// finish records that a call has been answered.
+func (st *serverState) finish() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.calls--; st.calls == 0 && st.idle != nil {
+		close(st.idle)
+		st.idle = nil
+	}
+}
+
+// drain stops new calls from being served, and returns a channel that is
+// closed once there are no calls in flight.
+func (st *serverState) drain() <-chan struct{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.closing = true
+	idle := st.idle
+	if idle == nil {
+		idle = make(chan struct{})
+		if st.calls == 0 {
+			close(idle)
+		} else {
+			st.idle = idle
+		}
+	}
+	return idle
+}
+
+// serve serves s using the codec returned by f, after answering the host's
+// handshake as the named codec.  It returns when the client hangs up, or when
+// the Server has been shut down.
+func (s Server) serve(codec string, f func(io.ReadWriteCloser) rpc.ServerCodec) {
+	if !s.handshake(codec) {
+		return
+	}
+	st := s.state
+	if st == nil {
+		st = newServerState()
+	}
+	if st.signals && !signal.Ignored(os.Interrupt) {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt)
+		defer signal.Stop(sigs)
+		go func() {
+			select {
+			case <-sigs:
+				ctx, cancel := context.WithTimeout(context.Background(), hostGrace())
+				defer cancel()
+				s.Shutdown(ctx)
+			case <-st.closed:
+			}
+		}()
+	}
+	served := make(chan struct{})
+	go func() {
+		s.server.ServeCodec(trackingCodec{f(s.rwc), st})
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-st.closed:
+	}
+}
+
+// trackingCodec is a ServerCodec that keeps track of the calls in flight.
+// The rpc package answers every request whose header it reads, and stops
+// reading once the header can't be read.
+type trackingCodec struct {
+	rpc.ServerCodec
+	st *serverState
+}
+
+func (c trackingCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+	if !c.st.start() {
+		// The Server is shutting down, so the request is dropped, and the
+		// client will see the connection close instead of a reply.
+		return io.EOF
+	}
+	return nil
+}
+
+func (c trackingCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	defer c.st.finish()
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// gobServerCodec is the gob ServerCodec that rpc.Server.ServeConn uses, which
+// the rpc package doesn't export.
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+// newGobServerCodec returns a gob ServerCodec that talks over rwc.
+func newGobServerCodec(rwc io.ReadWriteCloser) rpc.ServerCodec {
+	buf := bufio.NewWriter(rwc)
+	return &gobServerCodec{
+		rwc:    rwc,
+		dec:    gob.NewDecoder(rwc),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.enc.Encode(r); err != nil {
+		// The header couldn't be encoded, so the stream is broken.
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
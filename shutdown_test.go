@@ -0,0 +1,98 @@
+package pie
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+	"os"
+	"testing"
+	"time"
+)
+
+// startServer serves the test APIs over pipes in this process, and returns a
+// client for them and a channel that is closed once serving is done.
+func startServer(t *testing.T) (Server, *rpc.Client, <-chan struct{}) {
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	s := Server{server: rpc.NewServer(), rwc: rwCloser{stdinR, stdoutW}, state: newServerState()}
+	s.RegisterName("helper", helper{})
+	done := make(chan struct{})
+	go func() {
+		s.ServeCodec(newGobServerCodec)
+		close(done)
+	}()
+	client := rpc.NewClient(rwCloser{stdoutR, stdinW})
+	t.Cleanup(func() { client.Close() })
+	return s, client, done
+}
+
+func TestShutdownDrainsCalls(t *testing.T) {
+	s, client, done := startServer(t)
+	call := client.Go("helper.Sleep", 200*time.Millisecond, nil, nil)
+	// Make sure the call has been received before shutting down.
+	if !eventually(func() bool {
+		s.state.mu.Lock()
+		defer s.state.mu.Unlock()
+		return s.state.calls == 1
+	}) {
+		t.Fatal("Call was never received")
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Unexpected error from Shutdown: %#v", err)
+	}
+	// The reply has been written by now, but the client reads it in its own
+	// goroutine.
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			t.Errorf("Unexpected error from call in flight: %#v", call.Error)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected call in flight to be answered when the Server was shut down")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeCodec didn't return after Shutdown")
+	}
+	if err := client.Call("helper.PID", 0, new(int)); err == nil {
+		t.Error("Expected error calling a Server that was shut down")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s, client, done := startServer(t)
+	client.Go("helper.Sleep", time.Hour, nil, nil)
+	if !eventually(func() bool {
+		s.state.mu.Lock()
+		defer s.state.mu.Unlock()
+		return s.state.calls == 1
+	}) {
+		t.Fatal("Call was never received")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded from Shutdown, got %#v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeCodec didn't return after Shutdown")
+	}
+}
+
+func TestGraceFor(t *testing.T) {
+	if g := graceFor(nil); g != "1000" {
+		t.Errorf("Expected default grace of 1000ms, got %q", g)
+	}
+	steps := []StopStep{
+		{Signal: os.Interrupt, Wait: 2 * time.Second},
+		{Signal: os.Kill, Wait: 500 * time.Millisecond},
+	}
+	if g := graceFor(steps); g != "2500" {
+		t.Errorf("Expected grace of 2500ms, got %q", g)
+	}
+}
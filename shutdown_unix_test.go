@@ -0,0 +1,33 @@
+//go:build unix
+
+package pie
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCloseWaitsForCalls(t *testing.T) {
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error from startHelper: %#v", err)
+	}
+	if err := p.Call("helper.PID", 0, new(int)); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	p.Go("helper.Sleep", 300*time.Millisecond, nil, nil)
+	// Give the call time to reach the plugin.
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if err := p.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v, plugin's stderr: %s", err, p.Stderr())
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("Expected Close to wait for the call in flight, returned after %s", d)
+	}
+	state, _ := p.Wait()
+	if state == nil || !state.Exited() || state.ExitCode() != 0 {
+		t.Fatalf("Expected plugin to exit cleanly, got %s", state)
+	}
+}
@@ -0,0 +1,155 @@
+package pie
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStderrLines is how many lines of a plugin application's stderr are kept by
+// default.
+const defaultStderrLines = 20
+
+// maxStderrLine is the longest stderr line that is kept.  Longer lines are
+// truncated, so that a plugin writing without newlines can't use up the host's
+// memory.
+const maxStderrLine = 1024
+
+// stderrDrain is how long to wait, after a plugin application has exited, for
+// the last of its stderr to be read.  Output still held by the plugin's own
+// children isn't waited for any longer than this.
+var stderrDrain = 100 * time.Millisecond
+
+// WithStderrLines sets how many of the last lines the plugin application wrote
+// to stderr are kept, to be included in the error returned when it exits
+// unexpectedly or has to be killed.  The default is 20.  Zero or less turns
+// this off, which leaves the plugin writing directly to the output writer.
+func WithStderrLines(n int) Option {
+	return func(cfg *config) { cfg.stderrLines = n }
+}
+
+// stderrTail keeps the last lines written to a plugin application's stderr,
+// while passing everything written on to the original writer.
+type stderrTail struct {
+	// w is the plugin's end of the pipe, which the host closes once the
+	// plugin has started.
+	w *os.File
+	// done is closed when the plugin's stderr has been read to the end.
+	done chan struct{}
+
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial bytes.Buffer
+}
+
+// newStderrTail returns a stderrTail that keeps the last n lines written by
+// the process started by c, and replaces c's Stderr with the pipe it reads
+// from.
+func newStderrTail(c execCmd, n int) (*stderrTail, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	t := &stderrTail{
+		w:     w,
+		done:  make(chan struct{}),
+		lines: make([]string, n),
+	}
+	go t.copy(r, c.Stderr)
+	c.Stderr = w
+	return t, nil
+}
+
+// copy reads r to the end, keeping its last lines and writing everything to
+// out, if it isn't nil.  Errors writing to out are ignored, so that a broken
+// output writer doesn't block the plugin.
+func (t *stderrTail) copy(r *os.File, out io.Writer) {
+	defer close(t.done)
+	defer r.Close()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			t.Write(buf[:n])
+			if out != nil {
+				out.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// started closes the host's copy of the plugin's end of the pipe, once the
+// plugin has been started or has failed to start.
+func (t *stderrTail) started() {
+	if t != nil {
+		t.w.Close()
+	}
+}
+
+// Write records the lines in p.  It never fails.
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range p {
+		if b == '\n' {
+			t.add(t.partial.String())
+			t.partial.Reset()
+			continue
+		}
+		if t.partial.Len() < maxStderrLine {
+			t.partial.WriteByte(b)
+		}
+	}
+	return len(p), nil
+}
+
+// add adds a line to the ring of lines, replacing the oldest if it is full.
+func (t *stderrTail) add(line string) {
+	t.lines[t.next] = line
+	if t.next++; t.next == len(t.lines) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+// String returns the lines kept so far, including any unfinished last line.
+func (t *stderrTail) String() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lines []string
+	if t.full {
+		lines = append(lines, t.lines[t.next:]...)
+	}
+	lines = append(lines, t.lines[:t.next]...)
+	if t.partial.Len() > 0 {
+		lines = append(lines, t.partial.String())
+	}
+	if len(lines) > len(t.lines) {
+		lines = lines[len(lines)-len(t.lines):]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// last returns the lines kept once the plugin application has exited, giving
+// the rest of its stderr a moment to arrive.
+func (t *stderrTail) last() string {
+	if t == nil {
+		return ""
+	}
+	select {
+	case <-t.done:
+	case <-time.After(stderrDrain):
+	}
+	return t.String()
+}
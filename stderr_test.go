@@ -0,0 +1,138 @@
+package pie
+
+import (
+	"errors"
+	"io"
+	"net/rpc"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStderrTail(t *testing.T) {
+	tail := &stderrTail{lines: make([]string, 3)}
+	if s := tail.String(); s != "" {
+		t.Fatalf("Expected no lines, got %q", s)
+	}
+	tail.Write([]byte("one\ntwo\n"))
+	if s := tail.String(); s != "one\ntwo" {
+		t.Fatalf("Expected %q, got %q", "one\ntwo", s)
+	}
+	tail.Write([]byte("three\nfour\nfi"))
+	tail.Write([]byte("ve"))
+	if s := tail.String(); s != "three\nfour\nfive" {
+		t.Fatalf("Expected %q, got %q", "three\nfour\nfive", s)
+	}
+	tail.Write([]byte("\n" + strings.Repeat("x", 2*maxStderrLine) + "\n"))
+	if s := tail.String(); s != "four\nfive\n"+strings.Repeat("x", maxStderrLine) {
+		t.Fatalf("Expected long line to be truncated, got %q", s)
+	}
+}
+
+func TestStderrTailNil(t *testing.T) {
+	var tail *stderrTail
+	tail.started()
+	if s := tail.last(); s != "" {
+		t.Fatalf("Expected no lines, got %q", s)
+	}
+}
+
+func TestStderrInExitError(t *testing.T) {
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	defer p.Close()
+
+	err = p.Call("helper.Fail", "something went horribly wrong", nil)
+	if !errors.Is(err, rpc.ErrShutdown) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected call error to wrap the client's error, got %#v", err)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Expected call error to wrap an *ExitError, got %#v", err)
+	}
+	if !strings.Contains(exitErr.Stderr, "something went horribly wrong") {
+		t.Errorf("Expected stderr in the exit error, got %q", exitErr.Stderr)
+	}
+	if !strings.Contains(p.Err().Error(), "something went horribly wrong") {
+		t.Errorf("Expected stderr in Err, got %q", p.Err())
+	}
+	if !strings.Contains(p.Stderr(), "something went horribly wrong") {
+		t.Errorf("Expected stderr from Stderr, got %q", p.Stderr())
+	}
+}
+
+func TestStderrInCloseAndWait(t *testing.T) {
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	p.Call("helper.Fail", "something went horribly wrong", nil)
+
+	var exitErr *ExitError
+	if _, err := p.Wait(); !errors.As(err, &exitErr) {
+		t.Fatalf("Expected *ExitError from Wait, got %#v", err)
+	}
+	if !strings.Contains(exitErr.Stderr, "something went horribly wrong") {
+		t.Errorf("Expected stderr in the error from Wait, got %q", exitErr.Stderr)
+	}
+	exitErr = nil
+	if err := p.Close(); !errors.As(err, &exitErr) {
+		t.Fatalf("Expected *ExitError from Close, got %#v", err)
+	}
+	if !strings.Contains(exitErr.Stderr, "something went horribly wrong") {
+		t.Errorf("Expected stderr in the error from Close, got %q", exitErr.Stderr)
+	}
+}
+
+func TestCloseAfterSuccessfulExit(t *testing.T) {
+	p, err := startHelper(t, "provider")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	p.Call("helper.Exit", 0, nil)
+
+	// Like exec.Cmd's Wait, a successful exit isn't an error.
+	if _, err := p.Wait(); err != nil {
+		t.Errorf("Unexpected error from Wait: %#v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Unexpected error from Close: %#v", err)
+	}
+}
+
+func TestStderrInStopTimeoutError(t *testing.T) {
+	defer func(d time.Duration) { procTimeout = d }(procTimeout)
+	procTimeout = 50 * time.Millisecond
+	p, err := startHelper(t, "ignore-interrupt")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	if err := p.Call("helper.Print", "still here", nil); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	var stopErr *StopTimeoutError
+	if err := p.Close(); !errors.As(err, &stopErr) {
+		t.Fatalf("Expected *StopTimeoutError from Close, got %#v", err)
+	}
+	if !strings.Contains(stopErr.Stderr, "still here") {
+		t.Errorf("Expected stderr in the stop timeout error, got %q", stopErr.Stderr)
+	}
+}
+
+func TestWithStderrLinesOff(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"), WithStderrLines(0))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	defer p.Close()
+	if err := p.Call("helper.Print", "not kept", nil); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if s := p.Stderr(); s != "" {
+		t.Errorf("Expected no stderr to be kept, got %q", s)
+	}
+}
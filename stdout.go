@@ -0,0 +1,20 @@
+package pie
+
+import (
+	"os"
+	"sync"
+)
+
+var (
+	stdoutOnce sync.Once
+	stdoutFile *os.File
+)
+
+// stdout returns the file a plugin application uses to write to its host over
+// stdio.  The first time it is called, the real stdout is set aside for the
+// RPC stream, and os.Stdout is redirected to stderr, so that stray prints from
+// the plugin or the libraries it uses can't corrupt the stream.
+func stdout() *os.File {
+	stdoutOnce.Do(func() { stdoutFile = guardStdout() })
+	return stdoutFile
+}
@@ -0,0 +1,9 @@
+package pie
+
+import "syscall"
+
+// dup2 makes newfd a copy of oldfd.  Not every linux architecture has the
+// dup2 system call, but they all have dup3.
+func dup2(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package pie
+
+import "os"
+
+// guardStdout is not supported on this platform, so the RPC stream uses
+// os.Stdout directly.
+func guardStdout() *os.File {
+	return os.Stdout
+}
@@ -0,0 +1,9 @@
+package pie
+
+import "syscall"
+
+// dup2 is not available from package syscall on solaris, so stdout is left
+// alone there.
+func dup2(oldfd, newfd int) error {
+	return syscall.ENOTSUP
+}
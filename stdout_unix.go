@@ -0,0 +1,30 @@
+//go:build unix
+
+package pie
+
+import (
+	"os"
+	"syscall"
+)
+
+// guardStdout moves this application's real stdout to a new file descriptor,
+// and points file descriptor 1 at stderr instead, so that anything else that
+// writes to stdout ends up in the host's output rather than in the RPC stream.
+// It returns the file for the real stdout.  If stdout can't be moved, it
+// returns os.Stdout.
+func guardStdout() *os.File {
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Dup(1)
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return os.Stdout
+	}
+	if err := dup2(2, 1); err != nil {
+		syscall.Close(fd)
+		return os.Stdout
+	}
+	return os.NewFile(uintptr(fd), "/dev/stdout")
+}
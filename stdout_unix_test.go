@@ -0,0 +1,43 @@
+//go:build unix
+
+package pie
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStdoutGuard(t *testing.T) {
+	output := &syncBuffer{}
+	t.Setenv(helperEnv, "1")
+	p, err := StartProvider(output, os.Args[0], helperArgs("provider")...)
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProvider: %#v", err)
+	}
+	defer p.Close()
+
+	// If the prints corrupted the RPC stream, the calls would never finish.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if err := Call(ctx, p, "helper.Print", "hello from stdout", nil); err != nil {
+			t.Fatalf("Unexpected error from Call after printing to stdout: %#v", err)
+		}
+	}
+	var response string
+	if err := Call(ctx, p, "api.SayHi", "bob", &response); err != nil {
+		t.Fatalf("Unexpected error from Call after printing to stdout: %#v", err)
+	}
+	if response != "Hi bob" {
+		t.Fatalf("Wrong response from api call, expected %q, got %q", "Hi bob", response)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	if !eventually(func() bool { return strings.Contains(output.String(), "hello from stdout") }) {
+		t.Errorf("Expected plugin's stdout to be redirected to output, got %q", output.String())
+	}
+}
@@ -0,0 +1,93 @@
+package pie
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// streamsEnv tells a provider plugin application that its host multiplexes the
+// connection between them using a Session.
+const streamsEnv = "PIE_STREAMS"
+
+// WithStreams multiplexes the connection to a provider plugin using a Session,
+// as for bidirectional plugins, so that RPC methods on either side can take or
+// return StreamRefs to send data that is too large to hold in memory all at
+// once.  The Plugin's Session and the plugin's Server's Session are then
+// non-nil.  The plugin application must use NewProvider, which multiplexes the
+// connection when the host asks it to.  If the host also uses WithHandshake,
+// NewProvider answers the handshake before returning, so the services
+// registered with the Server aren't listed in the plugin's PluginInfo.
+// WithStreams has no effect on consumer plugins.
+func WithStreams() Option {
+	return func(cfg *config) { cfg.streams = true }
+}
+
+// StreamRef refers to a Stream in the arguments or reply of an RPC call, so
+// that the other side can read or write the stream's data incrementally, with
+// flow control, instead of it being sent as part of the call.  The side making
+// the call, or answering it, gets a StreamRef from its Session's OpenRef, and
+// the other side gets the Stream it refers to from its Session's Attach.
+// Either side may Reset the stream to cancel the transfer.
+type StreamRef struct {
+	// ID is the id of the stream referred to.
+	ID uint32
+}
+
+// OpenRef opens a new stream for the other side to Attach to, and returns it
+// along with a StreamRef to send to the other side in an RPC call's arguments
+// or reply.  The StreamRef must be sent over the same Session.  Since the
+// stream is opened before the StreamRef is sent, the other side can attach as
+// soon as it receives it.
+func (s *Session) OpenRef() (*Stream, StreamRef, error) {
+	st, err := s.open(flagSYN | flagRef)
+	if err != nil {
+		return nil, StreamRef{}, err
+	}
+	return st, StreamRef{ID: st.id}, nil
+}
+
+// Attach returns the stream referred to by ref, which the other side opened
+// using OpenRef.  Each stream can be attached only once.  Streams that are
+// never attached stay open until the other side closes or resets them, or the
+// Session ends.
+func (s *Session) Attach(ref StreamRef) (*Stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.pending[ref.ID]
+	if st == nil {
+		if s.ended {
+			return nil, s.err
+		}
+		return nil, fmt.Errorf("pie: no stream %d to attach", ref.ID)
+	}
+	delete(s.pending, ref.ID)
+	return st, nil
+}
+
+// providerConn multiplexes the connection to the host using a Session if the
+// host started this plugin application using WithStreams, after answering the
+// handshake, if any, on the connection itself.  It returns the connection for
+// the provider's RPC server, and the Session, which is nil if the host didn't
+// ask for streams.
+func providerConn(rwc io.ReadWriteCloser) (io.ReadWriteCloser, *Session) {
+	if os.Getenv(streamsEnv) == "" {
+		return rwc, nil
+	}
+	os.Unsetenv(streamsEnv)
+	logHandshake(answerHandshake(rwc, "", nil))
+	sess := newSession(rwc, false)
+	return sess.rpcConn(hostCalls), sess
+}
+
+// provide returns the Plugin for a provider plugin application that was
+// launched with cfg and is connected by pipe.
+func (cfg *config) provide(pipe ioPipe) *Plugin {
+	if !cfg.streams {
+		return newPlugin(cfg.client(pipe), pipe)
+	}
+	sess := newSession(pipe, true)
+	p := newPlugin(cfg.client(sess.rpcConn(hostCalls)), pipe)
+	p.session = sess
+	return p
+}
@@ -0,0 +1,163 @@
+package pie
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// streamer is an API served by TestHelperProcess that reads and writes the
+// streams passed to it by StreamRef.
+type streamer struct {
+	sess *Session
+}
+
+// Count reads the stream ref refers to until EOF, and replies with how many
+// bytes were read.
+func (s streamer) Count(ref StreamRef, n *int64) error {
+	if s.sess == nil {
+		return errors.New("streams not enabled")
+	}
+	st, err := s.sess.Attach(ref)
+	if err != nil {
+		return err
+	}
+	*n, err = io.Copy(ioutil.Discard, st)
+	return err
+}
+
+// Generate replies with a reference to a stream that size zero bytes are
+// written to.
+func (s streamer) Generate(size int64, ref *StreamRef) error {
+	if s.sess == nil {
+		return errors.New("streams not enabled")
+	}
+	st, r, err := s.sess.OpenRef()
+	if err != nil {
+		return err
+	}
+	go func() {
+		if _, err := io.CopyN(st, zeros{}, size); err != nil {
+			st.Reset()
+			return
+		}
+		st.Close()
+	}()
+	*ref = r
+	return nil
+}
+
+// zeros is an endless reader of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// startStreams starts the helper provider with streams enabled.
+func startStreams(t *testing.T) *Plugin {
+	t.Setenv(helperEnv, "1")
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"), WithStreams())
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	if p.Session() == nil {
+		t.Fatal("Expected plugin started with WithStreams to have a Session")
+	}
+	return p
+}
+
+func TestStreamArgument(t *testing.T) {
+	p := startStreams(t)
+	st, ref, err := p.Session().OpenRef()
+	if err != nil {
+		t.Fatalf("Unexpected error from OpenRef: %#v", err)
+	}
+	const size = 8 << 20
+	go func() {
+		io.CopyN(st, zeros{}, size)
+		st.Close()
+	}()
+	var n int64
+	if err := p.Call("streams.Count", ref, &n); err != nil {
+		t.Fatalf("Unexpected error from call with stream argument: %#v", err)
+	}
+	if n != size {
+		t.Errorf("Expected plugin to read %d bytes from stream, got %d", size, n)
+	}
+}
+
+func TestStreamResult(t *testing.T) {
+	p := startStreams(t)
+	const size = 8 << 20
+	var ref StreamRef
+	if err := p.Call("streams.Generate", int64(size), &ref); err != nil {
+		t.Fatalf("Unexpected error from call with stream result: %#v", err)
+	}
+	st, err := p.Session().Attach(ref)
+	if err != nil {
+		t.Fatalf("Unexpected error from Attach: %#v", err)
+	}
+	n, err := io.Copy(ioutil.Discard, st)
+	if err != nil || n != size {
+		t.Errorf("Expected %d bytes from stream until EOF, got %d, %#v", size, n, err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	p := startStreams(t)
+
+	// Resetting a stream the plugin is writing cancels the transfer.
+	var ref StreamRef
+	if err := p.Call("streams.Generate", int64(1<<40), &ref); err != nil {
+		t.Fatalf("Unexpected error from call with stream result: %#v", err)
+	}
+	st, err := p.Session().Attach(ref)
+	if err != nil {
+		t.Fatalf("Unexpected error from Attach: %#v", err)
+	}
+	if _, err := io.ReadFull(st, make([]byte, 1<<20)); err != nil {
+		t.Fatalf("Unexpected error reading stream: %#v", err)
+	}
+	st.Reset()
+	if _, err := st.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Errorf("Expected ErrStreamReset reading reset stream, got %#v", err)
+	}
+
+	// Resetting a stream the plugin is reading fails the call reading it.
+	st, ref, err = p.Session().OpenRef()
+	if err != nil {
+		t.Fatalf("Unexpected error from OpenRef: %#v", err)
+	}
+	call := p.Go("streams.Count", ref, new(int64), nil)
+	st.Write([]byte("partial"))
+	st.Reset()
+	<-call.Done
+	if call.Error == nil {
+		t.Error("Expected call reading reset stream to fail")
+	}
+
+	// The plugin carries on serving calls.
+	if err := p.Call("helper.PID", 0, new(int)); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+}
+
+func TestStreamsHandshake(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	p, err := StartProviderWith(os.Stderr, os.Args[0], helperArgs("provider"),
+		WithStreams(), WithHandshake(Handshake{}))
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	defer p.Close()
+	if err := p.Call("streams.Generate", int64(10), new(StreamRef)); err != nil {
+		t.Fatalf("Unexpected error from call after handshake: %#v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package pie
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ErrCrashLoop is returned by a Supervisor that stopped restarting its plugin
+// because the plugin restarted more often than its SupervisorConfig allows.
+var ErrCrashLoop = errors.New("plugin restarted too many times")
+
+// StartFunc starts a plugin application and returns a handle to it.  It is
+// usually a closure around StartProvider or StartProviderCodec.
+type StartFunc func() (*Plugin, error)
+
+// RestartPolicy determines whether a Supervisor restarts its plugin when the
+// plugin exits without having been closed by the host.
+type RestartPolicy int
+
+const (
+	// RestartNever never restarts the plugin.
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts the plugin if it exits with a non-zero exit
+	// status or is killed by a signal.
+	RestartOnFailure
+	// RestartAlways restarts the plugin whenever it exits.
+	RestartAlways
+)
+
+// SupervisorConfig configures how a Supervisor restarts its plugin.
+type SupervisorConfig struct {
+	// Policy determines when the plugin is restarted.
+	Policy RestartPolicy
+
+	// MinBackoff is how long to wait before the first restart.  Each
+	// consecutive restart waits twice as long as the previous one, up to
+	// MaxBackoff.  Once the plugin has stayed up for MaxBackoff, the backoff
+	// resets to MinBackoff.  They default to 100ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxRestarts is the number of restarts allowed within Window.  If the
+	// plugin needs to be restarted more often than that, the Supervisor gives
+	// up and stops with ErrCrashLoop.  Zero means there is no limit.  If
+	// Window is zero, every restart counts, however long ago it was.
+	MaxRestarts int
+	Window      time.Duration
+}
+
+// Supervisor runs a provider plugin and restarts it according to a
+// RestartPolicy when its process exits unexpectedly.  Calls made through the
+// Supervisor are sent to whichever instance of the plugin is currently
+// running.
+type Supervisor struct {
+	start StartFunc
+	cfg   SupervisorConfig
+
+	closing chan struct{}
+	done    chan struct{}
+
+	mu     sync.Mutex
+	plugin *Plugin
+	// changed is closed and replaced whenever plugin changes.
+	changed   chan struct{}
+	err       error
+	closeErr  error
+	closeOnce sync.Once
+}
+
+// Supervise starts a plugin using start and supervises it according to cfg.
+// It returns an error if the plugin cannot be started the first time.
+func Supervise(start StartFunc, cfg SupervisorConfig) (*Supervisor, error) {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	p, err := start()
+	if err != nil {
+		return nil, err
+	}
+	s := &Supervisor{
+		start:   start,
+		cfg:     cfg,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		plugin:  p,
+		changed: make(chan struct{}),
+	}
+	go s.run(p)
+	return s, nil
+}
+
+// Call invokes the named function on the currently running plugin, waits for
+// it to complete, and returns its error status.  If the plugin is being
+// restarted, Call waits for the restart to finish.  If the plugin exits while
+// the call is in progress, the returned error says why the plugin exited; the
+// call is not retried.
+func (s *Supervisor) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	call := <-s.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1)).Done
+	return call.Error
+}
+
+// Go invokes the named function on the currently running plugin
+// asynchronously, like rpc.Client.Go.  If the plugin is being restarted, Go
+// waits for the restart to finish before sending the call.  If the Supervisor
+// has stopped, the call fails with the error from Err.
+func (s *Supervisor) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	return goVia(func() (*Plugin, func(), error) {
+		p, err := s.current()
+		return p, func() {}, err
+	}, serviceMethod, args, reply, done)
+}
+
+// Done returns a channel that is closed when the Supervisor stops, either
+// because it was closed or because it stopped restarting its plugin.
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns nil while the Supervisor is running.  Once Done is closed, Err
+// returns why the Supervisor stopped.  If it was closed by the host, Err
+// returns ErrClosed.
+func (s *Supervisor) Err() error {
+	select {
+	case <-s.done:
+	default:
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close stops supervising the plugin and shuts down the plugin application.
+func (s *Supervisor) Close() error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeErr
+}
+
+// current returns the running plugin, waiting for it to be restarted if
+// necessary.
+func (s *Supervisor) current() (*Plugin, error) {
+	for {
+		s.mu.Lock()
+		p, changed, err := s.plugin, s.changed, s.err
+		s.mu.Unlock()
+		if p != nil {
+			select {
+			case <-p.Done():
+				// run hasn't noticed yet that this plugin exited.
+			default:
+				return p, nil
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		<-changed
+	}
+}
+
+// run watches the plugin and restarts it as needed until the Supervisor is
+// closed or gives up.
+func (s *Supervisor) run(p *Plugin) {
+	var restarts []time.Time
+	backoff := s.cfg.MinBackoff
+	started := time.Now()
+	for {
+		select {
+		case <-p.Done():
+		case <-s.closing:
+			err := p.Close()
+			s.stop(ErrClosed, err)
+			return
+		}
+		p.Close()
+		if !s.shouldRestart(p) {
+			s.stop(p.Err(), nil)
+			return
+		}
+		exitErr := p.Err()
+		s.setPlugin(nil)
+		if time.Since(started) >= s.cfg.MaxBackoff {
+			backoff = s.cfg.MinBackoff
+		}
+
+		for {
+			if s.cfg.MaxRestarts > 0 {
+				restarts = append(recent(restarts, s.cfg.Window), time.Now())
+				if len(restarts) > s.cfg.MaxRestarts {
+					within := ""
+					if s.cfg.Window > 0 {
+						within = " in " + s.cfg.Window.String()
+					}
+					s.stop(fmt.Errorf("%w: %d restarts%s, last exit: %v",
+						ErrCrashLoop, len(restarts)-1, within, exitErr), nil)
+					return
+				}
+			}
+			select {
+			case <-time.After(backoff):
+			case <-s.closing:
+				s.stop(ErrClosed, nil)
+				return
+			}
+			if backoff *= 2; backoff > s.cfg.MaxBackoff {
+				backoff = s.cfg.MaxBackoff
+			}
+			var err error
+			if p, err = s.start(); err == nil {
+				break
+			}
+			exitErr = err
+		}
+
+		started = time.Now()
+		s.setPlugin(p)
+	}
+}
+
+// setPlugin sets the plugin that calls are sent to.
+func (s *Supervisor) setPlugin(p *Plugin) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.plugin = p
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// shouldRestart reports whether the exited plugin should be restarted.
+func (s *Supervisor) shouldRestart(p *Plugin) bool {
+	if p.stoppedByHost() {
+		return false
+	}
+	switch s.cfg.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		state, err := p.Wait()
+		return err != nil || state == nil || !state.Success()
+	default:
+		return false
+	}
+}
+
+// stop records why the Supervisor stopped and releases anyone waiting on it.
+func (s *Supervisor) stop(err, closeErr error) {
+	s.mu.Lock()
+	s.err = err
+	s.closeErr = closeErr
+	s.mu.Unlock()
+	s.setPlugin(nil)
+	close(s.done)
+}
+
+// recent returns the times in ts that are within window of now, or all of them
+// if window is zero.
+func recent(ts []time.Time, window time.Duration) []time.Time {
+	if window <= 0 {
+		return ts
+	}
+	cutoff := time.Now().Add(-window)
+	for len(ts) > 0 && ts[0].Before(cutoff) {
+		ts = ts[1:]
+	}
+	return ts
+}
@@ -0,0 +1,133 @@
+package pie
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func superviseHelper(t *testing.T, cfg SupervisorConfig) *Supervisor {
+	s, err := Supervise(func() (*Plugin, error) {
+		return startHelper(t, "provider")
+	}, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error from Supervise: %#v", err)
+	}
+	return s
+}
+
+func TestSupervisorRestartsOnFailure(t *testing.T) {
+	s := superviseHelper(t, SupervisorConfig{
+		Policy:     RestartOnFailure,
+		MinBackoff: time.Millisecond,
+	})
+	defer s.Close()
+
+	var pid1, pid2 int
+	if err := s.Call("helper.PID", 0, &pid1); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if err := s.Call("helper.Exit", 1, nil); err == nil {
+		t.Fatal("Expected error from call that crashed the plugin")
+	}
+	if err := s.Call("helper.PID", 0, &pid2); err != nil {
+		t.Fatalf("Unexpected error from Call after restart: %#v", err)
+	}
+	if pid1 == pid2 {
+		t.Fatalf("Expected plugin to be restarted with a new process, but pid is still %d", pid1)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	if err := s.Err(); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Err after Close, got %#v", err)
+	}
+	if err := s.Call("helper.PID", 0, &pid2); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Call after Close, got %#v", err)
+	}
+}
+
+func TestSupervisorNoRestartOnSuccess(t *testing.T) {
+	s := superviseHelper(t, SupervisorConfig{
+		Policy:     RestartOnFailure,
+		MinBackoff: time.Millisecond,
+	})
+	defer s.Close()
+
+	s.Call("helper.Exit", 0, nil)
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Supervisor did not stop after plugin exited successfully")
+	}
+	if err := s.Err(); err == nil || err == ErrClosed {
+		t.Fatalf("Expected plugin exit error from Err, got %#v", err)
+	}
+}
+
+func TestSupervisorCrashLoop(t *testing.T) {
+	s := superviseHelper(t, SupervisorConfig{
+		Policy:      RestartAlways,
+		MinBackoff:  time.Millisecond,
+		MaxRestarts: 1,
+		Window:      time.Minute,
+	})
+	defer s.Close()
+
+	s.Call("helper.Exit", 1, nil)
+	s.Call("helper.Exit", 1, nil)
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Supervisor did not give up on crash looping plugin")
+	}
+	if err := s.Err(); !errors.Is(err, ErrCrashLoop) {
+		t.Fatalf("Expected ErrCrashLoop from Err, got %#v", err)
+	}
+}
+
+func TestSupervisorCrashLoopNoWindow(t *testing.T) {
+	// Without a Window, every restart counts towards MaxRestarts.
+	s := superviseHelper(t, SupervisorConfig{
+		Policy:      RestartAlways,
+		MinBackoff:  time.Millisecond,
+		MaxRestarts: 1,
+	})
+	defer s.Close()
+
+	s.Call("helper.Exit", 1, nil)
+	s.Call("helper.Exit", 1, nil)
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Supervisor did not give up on crash looping plugin")
+	}
+	if err := s.Err(); !errors.Is(err, ErrCrashLoop) {
+		t.Fatalf("Expected ErrCrashLoop from Err, got %#v", err)
+	}
+}
+
+func TestSupervisorGo(t *testing.T) {
+	s := superviseHelper(t, SupervisorConfig{
+		Policy:     RestartOnFailure,
+		MinBackoff: time.Millisecond,
+	})
+	defer s.Close()
+
+	// A call interrupted by the plugin crashing says why it exited.
+	call := <-s.Go("helper.Exit", 1, nil, nil).Done
+	var exitErr *ExitError
+	if !errors.As(call.Error, &exitErr) {
+		t.Fatalf("Expected ExitError from call that crashed the plugin, got %#v", call.Error)
+	}
+	var pid int
+	call = <-s.Go("helper.PID", 0, &pid, make(chan *rpc.Call, 1)).Done
+	if call.Error != nil || pid == 0 {
+		t.Fatalf("Unexpected result from Go after restart: %d, %#v", pid, call.Error)
+	}
+	s.Close()
+	if call := <-s.Go("helper.PID", 0, &pid, nil).Done; call.Error != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Go after Close, got %#v", call.Error)
+	}
+}
@@ -0,0 +1,94 @@
+package pie
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// rpcFDEnv is the environment variable that tells a plugin application which
+// file descriptor to use for RPC, when it was started with
+// WithSocketTransport.
+const rpcFDEnv = "PIE_RPC_FD"
+
+// WithSocketTransport makes the host and the plugin application communicate
+// over a socket passed to the plugin as an extra file, rather than over the
+// plugin's Stdin and Stdout.  The plugin finds the socket using an environment
+// variable, which NewProvider and NewConsumer do automatically.  That leaves
+// the plugin free to use Stdout however it likes; anything it writes there goes
+// to the same writer as its Stderr.
+//
+// WithSocketTransport is only supported on unix systems.
+func WithSocketTransport() Option {
+	return func(cfg *config) { cfg.socket = true }
+}
+
+// startSocket runs the plugin with one end of a socket pair as an extra file,
+// and returns an ioPipe that uses the other end to talk to the plugin.
+func startSocket(cmd commander) (_ ioPipe, err error) {
+	c, ok := cmd.(execCmd)
+	if !ok {
+		return ioPipe{}, errors.New("socket transport requires an exec.Cmd")
+	}
+	host, plugin, err := socketpair()
+	if err != nil {
+		return ioPipe{}, fmt.Errorf("can't create socket for plugin: %w", err)
+	}
+	// Once started, the plugin has its own copy of its end of the socket.
+	defer plugin.Close()
+	defer func() {
+		if err != nil {
+			host.Close()
+		}
+	}()
+
+	c.ExtraFiles = append(c.ExtraFiles, plugin)
+	if c.Env == nil {
+		c.Env = os.Environ()
+	}
+	c.Env = append(c.Env, fmt.Sprintf("%s=%d", rpcFDEnv, 2+len(c.ExtraFiles)))
+	if c.Stdout == nil {
+		c.Stdout = c.Stderr
+	}
+	proc, err := c.Start()
+	if err != nil {
+		return ioPipe{}, notFound(err)
+	}
+	return newIOPipe(host, noCloseWriter{host}, proc), nil
+}
+
+// stdio returns the connection a plugin application uses to talk to its host:
+// the socket passed by the host if it used WithSocketTransport, or else Stdin
+// and the real Stdout.
+func stdio() io.ReadWriteCloser {
+	if f := rpcFile(); f != nil {
+		return f
+	}
+	return rwCloser{os.Stdin, stdout()}
+}
+
+// rpcFile returns the file the host passed for RPC, or nil if it didn't pass
+// one.  The environment variable is cleared so that it isn't inherited by the
+// plugin's own child processes.
+func rpcFile() *os.File {
+	s := os.Getenv(rpcFDEnv)
+	if s == "" {
+		return nil
+	}
+	os.Unsetenv(rpcFDEnv)
+	fd, err := strconv.Atoi(s)
+	if err != nil || fd < 3 {
+		return nil
+	}
+	return openRPCFile(fd)
+}
+
+// noCloseWriter is a WriteCloser whose Close does nothing, for when the
+// underlying writer is closed by someone else.
+type noCloseWriter struct {
+	io.Writer
+}
+
+func (noCloseWriter) Close() error { return nil }
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package pie
+
+import (
+	"errors"
+	"os"
+)
+
+// socketpair is not supported on this platform.
+func socketpair() (host, plugin *os.File, err error) {
+	return nil, nil, errors.New("socket transport is not supported on this platform")
+}
+
+// openRPCFile returns the file for the inherited file descriptor fd.
+func openRPCFile(fd int) *os.File {
+	return os.NewFile(uintptr(fd), "pie-rpc")
+}
@@ -0,0 +1,67 @@
+//go:build unix
+
+package pie
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSocketTransport(t *testing.T) {
+	output := &syncBuffer{}
+	p, err := StartProviderWith(output, os.Args[0], helperArgs("provider"),
+		WithEnv(helperEnv+"=1"), WithSocketTransport())
+	if err != nil {
+		t.Fatalf("Unexpected error from StartProviderWith: %#v", err)
+	}
+	defer p.Close()
+
+	// Printing to stdout would corrupt the RPC stream if it were running over
+	// stdout.
+	for i := 0; i < 3; i++ {
+		if err := p.Call("helper.Print", "hello from stdout", nil); err != nil {
+			t.Fatalf("Unexpected error from Call: %#v", err)
+		}
+	}
+	var response string
+	if err := p.Call("api.SayHi", "bob", &response); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if response != "Hi bob" {
+		t.Fatalf("Wrong response from api call, expected %q, got %q", "Hi bob", response)
+	}
+	var value string
+	if err := p.Call("helper.Getenv", rpcFDEnv, &value); err != nil {
+		t.Fatalf("Unexpected error from Call: %#v", err)
+	}
+	if value != "" {
+		t.Errorf("Expected %s to be cleared from the plugin's environment, got %q", rpcFDEnv, value)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close: %#v", err)
+	}
+	if !eventually(func() bool { return strings.Contains(output.String(), "hello from stdout") }) {
+		t.Errorf("Expected plugin's stdout to be written to output, got %q", output.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
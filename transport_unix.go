@@ -0,0 +1,30 @@
+//go:build unix
+
+package pie
+
+import (
+	"os"
+	"syscall"
+)
+
+// socketpair returns the two ends of a connected unix socket.  Both are
+// close-on-exec.
+func socketpair() (host, plugin *os.File, err error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	// A non-blocking file uses the runtime poller, so closing it interrupts
+	// any read in progress.  The plugin's end is left blocking, since that's
+	// what a child process expects of an inherited file.
+	syscall.SetNonblock(fds[0], true)
+	return os.NewFile(uintptr(fds[0]), "pie-host"), os.NewFile(uintptr(fds[1]), "pie-plugin"), nil
+}
+
+// openRPCFile returns the file for the inherited file descriptor fd.
+func openRPCFile(fd int) *os.File {
+	syscall.SetNonblock(fd, true)
+	return os.NewFile(uintptr(fd), "pie-rpc")
+}
@@ -0,0 +1,149 @@
+package pie
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureExt is the extension of the detached signature file checked by
+// WithSignature.  The signature for a plugin at path is read from
+// path+SignatureExt.
+const SignatureExt = ".sig"
+
+// WithSHA256 makes the host refuse to start the plugin application unless the
+// SHA-256 hash of its executable is one of sums, given in hex.
+//
+// The executable is checked just before it is started, and the file that is
+// checked is the one that is run: a relative path is resolved against the
+// plugin's working directory, if it has one, and then made absolute.  Like
+// the other verification options, this protects against a plugin that was
+// tampered with before the host started, not against one that is replaced at
+// the same moment by someone who can write to it.
+func WithSHA256(sums ...string) Option {
+	return func(cfg *config) { cfg.sums = append(cfg.sums, sums...) }
+}
+
+// WithAllowlist makes the host refuse to start the plugin application unless
+// the SHA-256 hash of its executable is listed in the file at path.  The file
+// has one hash per line, in hex, optionally followed by a file name as written
+// by sha256sum, which is ignored.  Blank lines and lines starting with # are
+// skipped.  The file is read every time a plugin is started, so that it may be
+// updated while the host is running.
+func WithAllowlist(path string) Option {
+	return func(cfg *config) { cfg.allowlist = path }
+}
+
+// WithSignature makes the host refuse to start the plugin application unless
+// its executable is signed by key.  The ed25519 signature of the whole
+// executable is read from a file next to it, named with SignatureExt, which
+// holds the signature either raw or base64 encoded.
+func WithSignature(key ed25519.PublicKey) Option {
+	return func(cfg *config) { cfg.signer = key }
+}
+
+// verifying reports whether any verification options were given.
+func (cfg *config) verifying() bool {
+	return len(cfg.sums) > 0 || cfg.allowlist != "" || cfg.signer != nil
+}
+
+// executable returns the absolute path of cmd's executable.  As when cmd is
+// run, a relative path is relative to cmd's working directory.
+func executable(cmd *exec.Cmd) (string, error) {
+	path := cmd.Path
+	if !filepath.IsAbs(path) && cmd.Dir != "" {
+		path = filepath.Join(cmd.Dir, path)
+	}
+	return filepath.Abs(path)
+}
+
+// verify checks the executable at path according to cfg.
+func (cfg *config) verify(path string) error {
+	if !cfg.verifying() {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return notFound(err)
+	}
+	sum := sha256.Sum256(b)
+	hexSum := hex.EncodeToString(sum[:])
+	if len(cfg.sums) > 0 && !containsFold(cfg.sums, hexSum) {
+		return fmt.Errorf("%w: %s has unexpected sha256 %s", ErrUntrustedPlugin, path, hexSum)
+	}
+	if cfg.allowlist != "" {
+		allowed, err := readAllowlist(cfg.allowlist)
+		if err != nil {
+			return fmt.Errorf("can't read plugin allowlist: %w", err)
+		}
+		if !containsFold(allowed, hexSum) {
+			return fmt.Errorf("%w: %s has sha256 %s, which isn't in the allowlist", ErrUntrustedPlugin, path, hexSum)
+		}
+	}
+	if cfg.signer != nil {
+		sig, err := readSignature(path + SignatureExt)
+		if err != nil {
+			return fmt.Errorf("%w: can't read signature for %s: %w", ErrUntrustedPlugin, path, err)
+		}
+		if !ed25519.Verify(cfg.signer, b, sig) {
+			return fmt.Errorf("%w: %s has an invalid signature", ErrUntrustedPlugin, path)
+		}
+	}
+	return nil
+}
+
+// readAllowlist returns the hashes listed in the allowlist file at path.
+func readAllowlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sums []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		sums = append(sums, fields[0])
+	}
+	return sums, scanner.Err()
+}
+
+// readSignature reads a raw or base64 encoded ed25519 signature from the file
+// at path.
+func readSignature(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == ed25519.SignatureSize {
+		return b, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, errors.New("not an ed25519 signature")
+	}
+	return sig, nil
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package pie
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// helperSum returns the hex SHA-256 hash of this test binary.
+func helperSum(t *testing.T) string {
+	b, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// startVerified starts this test binary at path as a provider plugin with the
+// given verification options, and closes it if it started.
+func startVerified(t *testing.T, path string, opts ...Option) error {
+	opts = append(opts, WithEnv(helperEnv+"=1"))
+	p, err := StartProviderWith(os.Stderr, path, helperArgs("provider"), opts...)
+	if err == nil {
+		p.Close()
+	}
+	return err
+}
+
+func TestWithSHA256(t *testing.T) {
+	sum := helperSum(t)
+	if err := startVerified(t, os.Args[0], WithSHA256(strings.Repeat("0", 64), strings.ToUpper(sum))); err != nil {
+		t.Errorf("Unexpected error starting plugin with matching sha256: %#v", err)
+	}
+	err := startVerified(t, os.Args[0], WithSHA256(strings.Repeat("0", 64)))
+	if !errors.Is(err, ErrUntrustedPlugin) {
+		t.Errorf("Expected ErrUntrustedPlugin for mismatched sha256, got %#v", err)
+	}
+}
+
+func TestVerifyRelativeToDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	// The host's working directory and the plugin's have different
+	// executables with the same relative name.
+	hostDir, pluginDir := t.TempDir(), t.TempDir()
+	b, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pluginDir, "plugin"), b, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := []byte("#!/bin/sh\nexit 0\n")
+	if err := ioutil.WriteFile(filepath.Join(hostDir, "plugin"), script, 0755); err != nil {
+		t.Fatal(err)
+	}
+	scriptSum := sha256.Sum256(script)
+	t.Chdir(hostDir)
+
+	path := "./plugin"
+	err = startVerified(t, path, WithDir(pluginDir), WithSHA256(hex.EncodeToString(scriptSum[:])))
+	if !errors.Is(err, ErrUntrustedPlugin) {
+		t.Errorf("Expected ErrUntrustedPlugin for file in the host's directory, got %#v", err)
+	}
+	if err := startVerified(t, path, WithDir(pluginDir), WithSHA256(helperSum(t))); err != nil {
+		t.Errorf("Unexpected error starting plugin verified in its own directory: %#v", err)
+	}
+}
+
+func TestWithAllowlist(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "plugins.sha256")
+	write := func(s string) {
+		if err := ioutil.WriteFile(list, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("# approved plugins\n\n" + strings.Repeat("1", 64) + "  other\n")
+	err := startVerified(t, os.Args[0], WithAllowlist(list))
+	if !errors.Is(err, ErrUntrustedPlugin) {
+		t.Errorf("Expected ErrUntrustedPlugin for unlisted plugin, got %#v", err)
+	}
+	write(strings.Repeat("1", 64) + "  other\n" + helperSum(t) + "  " + filepath.Base(os.Args[0]) + "\n")
+	if err := startVerified(t, os.Args[0], WithAllowlist(list)); err != nil {
+		t.Errorf("Unexpected error starting listed plugin: %#v", err)
+	}
+	err = startVerified(t, os.Args[0], WithAllowlist(filepath.Join(t.TempDir(), "missing")))
+	if err == nil {
+		t.Error("Expected error for missing allowlist")
+	}
+}
+
+func TestWithSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(pluginDir(t, "signed"), "signed")
+
+	err = startVerified(t, path, WithSignature(pub))
+	if !errors.Is(err, ErrUntrustedPlugin) {
+		t.Errorf("Expected ErrUntrustedPlugin for missing signature, got %#v", err)
+	}
+
+	sig := ed25519.Sign(priv, b)
+	for name, contents := range map[string][]byte{
+		"raw":    sig,
+		"base64": []byte(base64.StdEncoding.EncodeToString(sig) + "\n"),
+	} {
+		if err := ioutil.WriteFile(path+SignatureExt, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := startVerified(t, path, WithSignature(pub)); err != nil {
+			t.Errorf("%s: unexpected error starting signed plugin: %#v", name, err)
+		}
+	}
+
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = startVerified(t, path, WithSignature(other))
+	if !errors.Is(err, ErrUntrustedPlugin) {
+		t.Errorf("Expected ErrUntrustedPlugin for signature by another key, got %#v", err)
+	}
+}
+
+func TestVerifyNotFound(t *testing.T) {
+	err := startVerified(t, "pie-no-such-plugin", WithSHA256(strings.Repeat("0", 64)))
+	if !errors.Is(err, ErrPluginNotFound) {
+		t.Errorf("Expected ErrPluginNotFound, got %#v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package pie
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// pPID is the waitid idtype for waiting on a single process.
+const pPID = 1
+
+// waitExited waits for the process with the given id to exit, without reaping
+// it, and reports whether it could.
+func waitExited(pid int) bool {
+	// The siginfo_t that waitid fills in is 128 bytes on every architecture.
+	var info [128]byte
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid),
+			uintptr(unsafe.Pointer(&info[0])), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		if errno != syscall.EINTR {
+			return errno == 0
+		}
+	}
+}
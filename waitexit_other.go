@@ -0,0 +1,9 @@
+//go:build unix && !linux
+
+package pie
+
+// waitExited reports false, because there's no portable way to wait for a
+// process to exit without reaping it on this system.
+func waitExited(pid int) bool {
+	return false
+}
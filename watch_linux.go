@@ -0,0 +1,59 @@
+package pie
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// watchFile returns a channel that receives a value whenever the file at path
+// is written, or replaced by another file, until stop is closed.  It watches
+// the file's directory rather than the file itself, since tools usually
+// replace a file by renaming a new one over it.
+func watchFile(path string, stop <-chan struct{}) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("can't watch %s: %w", path, err)
+	}
+	dir, name := filepath.Split(path)
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("can't watch %s: %w", path, err)
+	}
+	// Being non-blocking, the file can be closed while it is being read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-stop
+		f.Close()
+	}()
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				off += syscall.SizeofInotifyEvent
+				evName := string(bytes.TrimRight(buf[off:off+int(ev.Len)], "\x00"))
+				off += int(ev.Len)
+				if evName != name {
+					continue
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+					// A change is already waiting to be noticed.
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,47 @@
+//go:build !linux
+
+package pie
+
+import (
+	"os"
+	"time"
+)
+
+// watchPoll is how often watchFile checks for changes.
+var watchPoll = time.Second
+
+// watchFile returns a channel that receives a value whenever the file at path
+// is written, or replaced by another file, until stop is closed.  It checks
+// the file every watchPoll.
+func watchFile(path string, stop <-chan struct{}) (<-chan struct{}, error) {
+	last, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		t := time.NewTicker(watchPoll)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-stop:
+				return
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				// The file is being replaced; wait for the new one.
+				continue
+			}
+			if os.SameFile(fi, last) && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size() {
+				continue
+			}
+			last = fi
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}